@@ -0,0 +1,445 @@
+package dirk
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchiveEntry describes a member of an archive.
+type ArchiveEntry struct {
+	Name           string
+	Size           int64 // Size is the uncompressed size, -1 when unknown.
+	CompressedSize int64 // CompressedSize is -1 when the format keeps none per entry.
+	Mode           os.FileMode
+	ModTime        time.Time
+	Link           string // Link is the target of symbolic and hard links.
+	// Xattrs are the extended attributes a tar keeps for the entry.
+	Xattrs map[string]string
+}
+
+// IsDir reports whether the entry is a folder.
+func (e ArchiveEntry) IsDir() bool { return e.Mode.IsDir() }
+
+// ArchiveOptions control WriteArchive and ReadArchive.
+type ArchiveOptions struct {
+	// Level is the compression level, from 1 for the fastest to 9 for the
+	// smallest. Zero means the default of the format.
+	Level int
+	// Filter, when set, is called for every member and leaves out those it
+	// returns false for. Leaving out a folder leaves out all it holds.
+	Filter func(ArchiveEntry) bool
+	// Progress, when set, is called before every member is written or
+	// extracted.
+	Progress func(ArchiveEntry)
+	// Parallel is how many zip members are compressed at once; zero means
+	// one per CPU.
+	Parallel int
+	// Reproducible makes a tar depend on its content alone: members
+	// sorted, owners zeroed and every time set to ModTime.
+	Reproducible bool
+	// ModTime is the time of every member of a reproducible tar; zero
+	// means the Unix epoch.
+	ModTime time.Time
+	// XattrNamespaces are the namespaces of extended attributes restored
+	// on extraction besides "user", such as "security" or "trusted". Those
+	// can change what a file is allowed to do, so they are left out unless
+	// asked for.
+	XattrNamespaces []string
+}
+
+// WriteArchive packs the selection into w as an archive of kind "zip",
+// "tar" or a compressed tar such as "tar.gz", named relative to the common
+// parent of the selected files. A single file can also be written with a
+// kind of "gz", "bz2", "xz" or "zst".
+func (files Files) WriteArchive(w io.Writer, kind string, options ...ArchiveOptions) error {
+	if len(files) == 0 {
+		return ErrNoSelection
+	}
+	var opt ArchiveOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+	return writeArchive(files.paths(), w, kind, opt, nil)
+}
+
+// ReadArchive extracts the zip or tar, compressed or not, read from r into
+// the folder target, with the safeguards of Unarchive. Files already in
+// target are replaced by members of the same name. An empty kind is worked
+// out from the first bytes. A zip is first copied to a temporary file, as
+// its index is at its end.
+func ReadArchive(r io.Reader, kind, target string, options ...ArchiveOptions) error {
+	var opt ArchiveOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+	if kind == "" {
+		buffered := bufio.NewReader(r)
+		head, _ := buffered.Peek(512)
+		mime, _ := Detect(head)
+		if compression, ok := compressions[mime]; ok {
+			kind = "tar." + compression
+		} else {
+			kind = mimeKind(mime)
+		}
+		r = buffered
+	}
+	if tarred, compression := splitKind(kind); tarred {
+		return unpack(target, opt, nil, func(fn func(ArchiveEntry, func() (io.ReadCloser, error)) error) error {
+			return walkTar(r, compression, fn)
+		})
+	} else if kind != "zip" {
+		return fmt.Errorf("cannot extract %q, it is not a zip or tar", kind)
+	}
+
+	spool, err := ioutil.TempFile("", "dirk-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	size, err := io.Copy(spool, r)
+	if err != nil {
+		return err
+	}
+	reader, err := zip.NewReader(spool, size)
+	if err != nil {
+		return err
+	}
+	return unpack(target, opt, nil, func(fn func(ArchiveEntry, func() (io.ReadCloser, error)) error) error {
+		return walkZip(reader, fn)
+	})
+}
+
+// ArchiveEntries lists the members of a zip or tar archive, compressed or
+// not, or the content of a single compressed file.
+func (f File) ArchiveEntries() ([]ArchiveEntry, error) {
+	entries := []ArchiveEntry{}
+	err := f.EachEntry(func(e ArchiveEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// EachEntry calls fn for every member of the archive as it is read, so
+// large archives are never held in memory. Returning filepath.SkipDir from
+// fn stops the listing without an error.
+func (f File) EachEntry(fn func(ArchiveEntry) error) error {
+	err := eachEntry(f.Path, fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func eachEntry(path string, fn func(ArchiveEntry) error) error {
+	return walkArchive(path, func(e ArchiveEntry, _ func() (io.ReadCloser, error)) error {
+		return fn(e)
+	})
+}
+
+// walkArchive calls fn for every member of the archive with a function
+// opening its content. The content of tar and gz members can only be read
+// until fn returns.
+func walkArchive(path string, fn func(e ArchiveEntry, open func() (io.ReadCloser, error)) error) error {
+	kind := detectArchive(path)
+	if kind == "zip" {
+		reader, err := zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		return walkZip(&reader.Reader, fn)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if kind == "" {
+		return fmt.Errorf("%s: not a supported archive", path)
+	}
+	tarred, compression := splitKind(kind)
+	if tarred {
+		return walkTar(file, compression, fn)
+	}
+	content, err := decompressor(compression, file)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	entry, err := singleEntry(path, file, compression, content)
+	if err != nil {
+		return err
+	}
+	return fn(entry, func() (io.ReadCloser, error) { return ioutil.NopCloser(content), nil })
+}
+
+func walkZip(reader *zip.Reader, fn func(e ArchiveEntry, open func() (io.ReadCloser, error)) error) error {
+	for _, file := range reader.File {
+		entry := ArchiveEntry{
+			Name:           file.Name,
+			Size:           int64(file.UncompressedSize64),
+			CompressedSize: int64(file.CompressedSize64),
+			Mode:           file.Mode(),
+			ModTime:        file.Modified,
+		}
+		if entry.Mode&os.ModeSymlink != 0 {
+			// zip keeps the target of a link as its content
+			entry.Link, _ = readZipLink(file)
+		}
+		if err := fn(entry, file.Open); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTar reads a tar stream, compressed unless compression is empty.
+func walkTar(reader io.Reader, compression string, fn func(e ArchiveEntry, open func() (io.ReadCloser, error)) error) error {
+	if compression != "" {
+		content, err := decompressor(compression, reader)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		reader = content
+	}
+	tarReader := tar.NewReader(reader)
+	open := func() (io.ReadCloser, error) { return ioutil.NopCloser(tarReader), nil }
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF && compression != "" {
+			// read to the end of the stream, where its checksum is
+			_, err = io.Copy(ioutil.Discard, reader)
+			return err
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		err = fn(ArchiveEntry{
+			Name:           header.Name,
+			Size:           header.Size,
+			CompressedSize: -1,
+			Mode:           header.FileInfo().Mode(),
+			ModTime:        header.ModTime,
+			Link:           header.Linkname,
+			Xattrs:         tarXattrs(header),
+		}, open)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func readZipLink(file *zip.File) (string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	target, err := ioutil.ReadAll(io.LimitReader(reader, 4096))
+	return string(target), err
+}
+
+// tarXattrs collects the extended attributes kept in the PAX records of a
+// tar header.
+func tarXattrs(header *tar.Header) map[string]string {
+	var attrs map[string]string
+	for key, value := range header.PAXRecords {
+		if name := strings.TrimPrefix(key, "SCHILY.xattr."); name != key {
+			if attrs == nil {
+				attrs = map[string]string{}
+			}
+			attrs[name] = value
+		}
+	}
+	return attrs
+}
+
+// singleEntry describes the content of a compressed file. Only gzip keeps
+// a name, time and size, the others are named after the file.
+func singleEntry(path string, file *os.File, compression string, content io.Reader) (ArchiveEntry, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return ArchiveEntry{}, err
+	}
+	entry := ArchiveEntry{
+		Name:           strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Size:           -1,
+		CompressedSize: info.Size(),
+		Mode:           info.Mode().Perm(),
+		ModTime:        info.ModTime(),
+	}
+	if header, ok := content.(*gzip.Reader); ok {
+		if header.Name != "" {
+			entry.Name = filepath.Base(header.Name)
+		}
+		if !header.ModTime.IsZero() {
+			entry.ModTime = header.ModTime
+		}
+		trailer := make([]byte, 4)
+		if _, err := file.ReadAt(trailer, info.Size()-4); err != nil {
+			return ArchiveEntry{}, err
+		}
+		entry.Size = int64(binary.LittleEndian.Uint32(trailer))
+	}
+	return entry, nil
+}
+
+// TestArchive reads every member of the archive, checking the CRCs of zip
+// members and the checksums of the compressed streams, without extracting
+// anything. Corrupt members are reported as the failures of a Result, named
+// as paths inside the archive. A damaged tar or compressed stream cannot be
+// read past the damage, so it is reported as a failure of the archive.
+func (f File) TestArchive() error {
+	res := &Result{Op: "test"}
+	err := walkArchive(f.Path, func(e ArchiveEntry, open func() (io.ReadCloser, error)) error {
+		member := virtualPath(f.Path, cleanEntry(e.Name))
+		if !e.Mode.IsRegular() || e.Link != "" {
+			res.Succeeded = append(res.Succeeded, member)
+			return nil
+		}
+		content, err := open()
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, content)
+			content.Close()
+		}
+		if err != nil {
+			res.fail("test", member, err)
+		} else {
+			res.Succeeded = append(res.Succeeded, member)
+		}
+		return nil
+	})
+	if err != nil {
+		res.fail("test", f.Path, err)
+	}
+	return res.Err()
+}
+
+// AppendToArchive adds the selection to an existing zip or uncompressed
+// tar, named relative to the common parent of the selected files. Members
+// of the same name are replaced. A tar is appended to in place, the new
+// members following the old ones and winning over them when extracted or
+// browsed. A zip is rewritten with its untouched members copied as they
+// are, without being compressed again.
+func (files Files) AppendToArchive(archive File) error {
+	if len(files) == 0 {
+		return ErrNoSelection
+	}
+	switch detectArchive(archive.Path) {
+	case "zip":
+		return appendZip(archive.Path, files.paths())
+	case "tar":
+		return appendTar(archive.Path, files.paths())
+	}
+	return fmt.Errorf("%s: only zip and uncompressed tar archives can be appended to", archive.Path)
+}
+
+func appendZip(path string, sources []string) error {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	replaced := map[string]bool{}
+	err = walkSources(sources, ArchiveOptions{}, func(_, name string, _ os.FileInfo) error {
+		replaced[name] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, 0644, func(w io.Writer) error {
+		buffered := bufio.NewWriter(w)
+		archive := zip.NewWriter(buffered)
+		if err := archive.SetComment(reader.Comment); err != nil {
+			return err
+		}
+		for _, file := range reader.File {
+			if replaced[file.Name] {
+				continue
+			}
+			if err := archive.Copy(file); err != nil {
+				return err
+			}
+		}
+		if err := zipSources(archive, sources, ArchiveOptions{}, nil); err != nil {
+			return err
+		}
+		if err := archive.Close(); err != nil {
+			return err
+		}
+		return buffered.Flush()
+	})
+}
+
+func appendTar(path string, sources []string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	end, err := tarEnd(file)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		return err
+	}
+	buffered := bufio.NewWriter(file)
+	err = tarit(sources, buffered, ArchiveOptions{}, nil)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		// put the end of the archive back where it was
+		file.Truncate(end)
+		file.WriteAt(make([]byte, 1024), end)
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// tarEnd reads a tar through to find where its end-of-archive blocks start.
+func tarEnd(file *os.File) (int64, error) {
+	reader := tar.NewReader(file)
+	end := int64(0)
+	for {
+		_, err := reader.Next()
+		if err == io.EOF {
+			return end, nil
+		} else if err != nil {
+			return 0, err
+		}
+		if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+			return 0, err
+		}
+		position, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		// members are padded to whole blocks
+		end = (position + 511) &^ 511
+	}
+}
@@ -0,0 +1,377 @@
+package dirk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTree makes src with a.txt, b.log and skip/c.txt below dir.
+func testTree(t *testing.T, dir string) string {
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(src, "skip"), 0755)
+	os.WriteFile(filepath.Join(src, "a.txt"), bytes.Repeat([]byte("0123456789"), 500), 0644)
+	os.WriteFile(filepath.Join(src, "b.log"), []byte("log"), 0644)
+	os.WriteFile(filepath.Join(src, "skip", "c.txt"), []byte("c"), 0644)
+	return src
+}
+
+func TestWriteReadArchive(t *testing.T) {
+	src := testTree(t, t.TempDir())
+	files, _ := MakeFiles([]string{src})
+	filter := func(e ArchiveEntry) bool { return !strings.HasSuffix(e.Name, ".log") && e.Name != "src/skip/" }
+	for _, kind := range []string{"zip", "tar", "tar.gz", "tar.bz2", "tar.xz", "tar.zst"} {
+		for _, level := range []int{0, 1, 9} {
+			var buf bytes.Buffer
+			var written []string
+			err := files.WriteArchive(&buf, kind, ArchiveOptions{
+				Level:    level,
+				Filter:   filter,
+				Progress: func(e ArchiveEntry) { written = append(written, e.Name) },
+			})
+			if err != nil {
+				t.Fatalf("%s at %d: %v", kind, level, err)
+			}
+			if len(written) != 2 {
+				t.Fatalf("%s at %d: wrote %v", kind, level, written)
+			}
+			// the kind is given or worked out from the content
+			for _, readKind := range []string{kind, ""} {
+				target := filepath.Join(t.TempDir(), "out")
+				var read []string
+				err := ReadArchive(bytes.NewReader(buf.Bytes()), readKind, target, ArchiveOptions{
+					Progress: func(e ArchiveEntry) { read = append(read, e.Name) },
+				})
+				if err != nil {
+					t.Fatalf("%s read as %q: %v", kind, readKind, err)
+				}
+				if len(read) != 2 {
+					t.Fatalf("%s read as %q: got %v", kind, readKind, read)
+				}
+				if content, _ := os.ReadFile(filepath.Join(target, "src", "a.txt")); len(content) != 5000 {
+					t.Fatalf("%s read as %q: a.txt holds %d bytes", kind, readKind, len(content))
+				}
+				if _, err := os.Stat(filepath.Join(target, "src", "b.log")); err == nil {
+					t.Fatalf("%s read as %q: filtered member written", kind, readKind)
+				}
+			}
+		}
+	}
+}
+
+func TestReadArchiveFilter(t *testing.T) {
+	src := testTree(t, t.TempDir())
+	files, _ := MakeFiles([]string{src})
+	var buf bytes.Buffer
+	if err := files.WriteArchive(&buf, "tar"); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(t.TempDir(), "out")
+	err := ReadArchive(&buf, "", target, ArchiveOptions{Filter: func(e ArchiveEntry) bool { return e.Name != "src/skip/" }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "src", "skip", "c.txt")); err == nil {
+		t.Fatal("member of a filtered folder written")
+	}
+	if _, err := os.Stat(filepath.Join(target, "src", "b.log")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteReadArchiveErrors(t *testing.T) {
+	src := testTree(t, t.TempDir())
+	files, _ := MakeFiles([]string{src})
+	target := filepath.Join(t.TempDir(), "out")
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"level", files.WriteArchive(&bytes.Buffer{}, "tar.gz", ArchiveOptions{Level: 12})},
+		{"kind", files.WriteArchive(&bytes.Buffer{}, "rar")},
+		{"selection", Files{}.WriteArchive(&bytes.Buffer{}, "zip")},
+		{"folder as gz", files.WriteArchive(&bytes.Buffer{}, "gz")},
+		{"not an archive", ReadArchive(strings.NewReader("hello"), "", target)},
+	}
+	for _, test := range tests {
+		if test.err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+	if _, err := os.Stat(target); err == nil {
+		t.Error("target of a failed read left behind")
+	}
+}
+
+func TestTestArchive(t *testing.T) {
+	dir := t.TempDir()
+	src := testTree(t, dir)
+	tests := []struct {
+		name    string
+		sources []string
+		corrupt bool // corrupt tells whether damaging the data is noticed
+	}{
+		{"t.zip", []string{src}, true},
+		{"t.tar", []string{src}, false}, // a tar keeps no checksum of the data
+		{"t.tar.gz", []string{src}, true},
+		{"t.tar.xz", []string{src}, true},
+		{"t.tar.zst", []string{src}, true},
+		{"a.txt.gz", []string{filepath.Join(src, "a.txt")}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, test.name)
+			if err := archive(test.sources, path, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := (File{Path: path}).TestArchive(); err != nil {
+				t.Fatal(err)
+			}
+			data, _ := os.ReadFile(path)
+			at := bytes.Index(data, []byte("0123456789"))
+			if at < 0 {
+				at = len(data) / 2
+			}
+			data[at+3] ^= 0xff
+			damaged := filepath.Join(t.TempDir(), test.name)
+			os.WriteFile(damaged, data, 0644)
+			err := File{Path: damaged}.TestArchive()
+			var res *Result
+			if test.corrupt != errors.As(err, &res) || (test.corrupt && len(res.Failed) == 0) {
+				t.Fatalf("damage noticed: %v, want %v", err, test.corrupt)
+			}
+		})
+	}
+}
+
+func TestAppendToArchive(t *testing.T) {
+	for _, kind := range []string{"zip", "tar"} {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			src := testTree(t, dir)
+			path := filepath.Join(dir, "archive."+kind)
+			if err := archive([]string{src}, path, nil); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := os.ReadFile(path)
+			more := filepath.Join(dir, "more", "src")
+			os.MkdirAll(more, 0755)
+			os.WriteFile(filepath.Join(more, "b.log"), []byte("new log"), 0644)
+			os.WriteFile(filepath.Join(more, "d.txt"), []byte("d"), 0644)
+			files, _ := MakeFiles([]string{more})
+			if err := files.AppendToArchive(File{Path: path}); err != nil {
+				t.Fatal(err)
+			}
+			after, _ := os.ReadFile(path)
+			if kind == "tar" && !bytes.Equal(after[:len(before)-1024], before[:len(before)-1024]) {
+				t.Fatal("tar rewritten instead of appended to")
+			}
+			if err := (File{Path: path}).TestArchive(); err != nil {
+				t.Fatal(err)
+			}
+			target := filepath.Join(dir, "out")
+			if err := extract(path, target); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{"b.log": "new log", "d.txt": "d", "skip/c.txt": "c"}
+			for name, content := range want {
+				got, err := os.ReadFile(filepath.Join(target, "src", filepath.FromSlash(name)))
+				if err != nil || string(got) != content {
+					t.Errorf("%s: got %q, %v, want %q", name, got, err, content)
+				}
+			}
+			if got, _ := os.ReadFile(filepath.Join(target, "src", "a.txt")); len(got) != 5000 {
+				t.Errorf("a.txt holds %d bytes after appending", len(got))
+			}
+			browsed, err := readAll(virtualPath(path, "src/b.log"))
+			if err != nil || string(browsed) != "new log" {
+				t.Errorf("browsing finds %q, %v", browsed, err)
+			}
+		})
+	}
+}
+
+func TestAppendToArchiveRefusesCompressed(t *testing.T) {
+	dir := t.TempDir()
+	src := testTree(t, dir)
+	path := filepath.Join(dir, "archive.tar.gz")
+	if err := archive([]string{src}, path, nil); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(path)
+	files, _ := MakeFiles([]string{filepath.Join(src, "a.txt")})
+	if err := files.AppendToArchive(File{Path: path}); err == nil {
+		t.Fatal("appended to a compressed tar")
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+		t.Fatal("compressed tar changed")
+	}
+}
+
+func readAll(path string) ([]byte, error) {
+	r, err := openAny(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestArchiveEntries(t *testing.T) {
+	dir := t.TempDir()
+	src := testTree(t, dir)
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(filepath.Join(src, "a.txt"), when, when)
+	os.Symlink("a.txt", filepath.Join(src, "link"))
+	tests := []struct {
+		name       string
+		compressed bool // compressed tells whether entries know their compressed size
+	}{
+		{"t.zip", true},
+		{"t.tar", false},
+		{"t.tar.gz", false},
+		{"t.tar.xz", false},
+		{"t.tar.zst", false},
+	}
+	want := map[string]int64{"src/": 0, "src/a.txt": 5000, "src/b.log": 3, "src/link": 0, "src/skip/": 0, "src/skip/c.txt": 1}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if err := archive([]string{src}, path, nil); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := File{Path: path}.ArchiveEntries()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(entries) != len(want) {
+			t.Fatalf("%s: got %d entries", test.name, len(entries))
+		}
+		for _, e := range entries {
+			size, ok := want[e.Name]
+			if !ok || (!e.IsDir() && e.Mode&os.ModeSymlink == 0 && e.Size != size) {
+				t.Errorf("%s: %s of %d bytes", test.name, e.Name, e.Size)
+			}
+			if (e.CompressedSize >= 0) != test.compressed {
+				t.Errorf("%s: %s compressed to %d", test.name, e.Name, e.CompressedSize)
+			}
+			switch e.Name {
+			case "src/a.txt":
+				if !e.ModTime.Equal(when) || e.Mode.Perm() != 0644 {
+					t.Errorf("%s: a.txt has mode %v at %v", test.name, e.Mode, e.ModTime)
+				}
+			case "src/link":
+				if e.Mode&os.ModeSymlink == 0 || e.Link != "a.txt" {
+					t.Errorf("%s: link to %q with mode %v", test.name, e.Link, e.Mode)
+				}
+			case "src/skip/":
+				if !e.IsDir() {
+					t.Errorf("%s: skip has mode %v", test.name, e.Mode)
+				}
+			}
+		}
+		// the listing can stop early
+		var seen int
+		err = File{Path: path}.EachEntry(func(ArchiveEntry) error {
+			seen++
+			return filepath.SkipDir
+		})
+		if err != nil || seen != 1 {
+			t.Errorf("%s: stopped after %d entries with %v", test.name, seen, err)
+		}
+	}
+}
+
+func TestArchiveEntriesSingle(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.txt")
+	os.WriteFile(src, bytes.Repeat([]byte("x"), 1000), 0644)
+	for _, name := range []string{"a.txt.gz", "a.txt.xz", "a.txt.zst"} {
+		path := filepath.Join(dir, name)
+		if err := archive([]string{src}, path, nil); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := File{Path: path}.ArchiveEntries()
+		if err != nil || len(entries) != 1 || entries[0].Name != "a.txt" {
+			t.Fatalf("%s: got %+v, %v", name, entries, err)
+		}
+	}
+	if _, err := (File{Path: src}).ArchiveEntries(); err == nil {
+		t.Fatal("listed a text file")
+	}
+}
+
+func TestArchiveSelection(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "x", "sub"), 0755)
+	os.MkdirAll(filepath.Join(dir, "y", "three"), 0755)
+	os.WriteFile(filepath.Join(dir, "x", "one.txt"), []byte("one"), 0644)
+	os.WriteFile(filepath.Join(dir, "x", "sub", "two.txt"), []byte("two"), 0644)
+	os.WriteFile(filepath.Join(dir, "y", "three", "f"), []byte("f"), 0644)
+	files, _ := MakeFiles([]string{
+		filepath.Join(dir, "x", "one.txt"),
+		filepath.Join(dir, "x", "sub"),
+		filepath.Join(dir, "y", "three"),
+	})
+	// members are named from the parent the selection shares
+	want := []string{"x/one.txt", "x/sub/", "x/sub/two.txt", "y/three/", "y/three/f"}
+	tests := []struct {
+		name, target string
+	}{
+		{"out.zip", "out.zip"},
+		{"out.tar", "out.tar"},
+		{"out.tar.gz", "out.tar.gz"},
+		{"out.tar.gz", "out(1).tar.gz"}, // a taken name is numbered before the extension
+	}
+	for _, test := range tests {
+		plan, err := files.ArchivePlan(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target := filepath.Join(dir, test.target)
+		if len(plan.Actions) != 1 || plan.Actions[0].Target != target || len(plan.Actions[0].Sources) != 3 || plan.Actions[0].Size != 7 {
+			t.Fatalf("%s: planned %+v", test.target, plan.Actions)
+		}
+		if err := plan.Execute(); err != nil {
+			t.Fatalf("%s: %v", test.target, err)
+		}
+		entries, err := File{Path: target}.ArchiveEntries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("%s holds %v", test.target, names)
+		}
+	}
+
+	single, _ := MakeFiles([]string{filepath.Join(dir, "x", "one.txt")})
+	if err := single.Archive("one.txt.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := readAll(filepath.Join(dir, "x", "one.txt.gz") + "//one.txt"); string(content) != "one" {
+		t.Errorf("gz holds %q, %v", content, err)
+	}
+	// a compressor alone cannot hold a selection, and nothing is left behind
+	err := files.Archive("out.gz")
+	var res *Result
+	if !errors.As(err, &res) || len(res.Failed) != 1 {
+		t.Fatalf("got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.gz")); !os.IsNotExist(err) {
+		t.Errorf("out.gz left behind: %v", err)
+	}
+	if _, err := Files(nil).ArchivePlan("out.zip"); err != ErrNoSelection {
+		t.Errorf("empty selection gives %v", err)
+	}
+}
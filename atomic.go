@@ -0,0 +1,84 @@
+package dirk
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// writeAtomic replaces name with whatever fill writes. The data goes to a
+// temporary file in the same directory which is synced and renamed over the
+// target, and the directory is synced afterwards, so readers and crashes see
+// either the old or the new content. An existing target keeps its mode and,
+// as far as permissions allow, its ownership; a new one is created with perm.
+func writeAtomic(name string, perm os.FileMode, fill func(w io.Writer) error) (err error) {
+	if real, err := filepath.EvalSymlinks(name); err == nil {
+		name = real
+	}
+	dir := filepath.Dir(name)
+	info, statErr := os.Stat(name)
+	if statErr == nil {
+		perm = info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	}
+	tmp, err := createTemp(dir, filepath.Base(name), perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if err = fill(tmp); err != nil {
+		return err
+	}
+	if statErr == nil {
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			if err = tmp.Chown(int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+				return err
+			}
+		}
+		if err = tmp.Chmod(perm); err != nil {
+			return err
+		}
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// createTemp makes a hidden sibling of base in dir. Unlike ioutil.TempFile it
+// honours perm, so new files end up with the usual umask applied.
+func createTemp(dir, base string, perm os.FileMode) (*os.File, error) {
+	for {
+		name := filepath.Join(dir, "."+base+".tmp"+strconv.Itoa(rand.Int()))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
+
+// syncDir flushes a directory entry change such as a rename to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && err.(*os.PathError).Err != syscall.EINVAL {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package dirk
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// leftovers returns the names in dir other than the given ones.
+func leftovers(dir string, names ...string) []string {
+	entries, _ := os.ReadDir(dir)
+	var rest []string
+	for _, entry := range entries {
+		known := false
+		for _, name := range names {
+			known = known || entry.Name() == name
+		}
+		if !known {
+			rest = append(rest, entry.Name())
+		}
+	}
+	return rest
+}
+
+func TestWriteAtomic(t *testing.T) {
+	errFill := errors.New("fill failed")
+	tests := []struct {
+		name    string
+		old     string // old is the content before, "" for no file
+		mode    os.FileMode
+		fill    string
+		err     error
+		want    string
+		wantMod os.FileMode
+	}{
+		{"new", "", 0, "new", nil, "new", 0640},
+		{"replace", "old", 0604, "new", nil, "new", 0604},
+		{"failed fill", "old", 0600, "", errFill, "old", 0600},
+		{"failed new", "", 0, "", errFill, "", 0},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "file")
+		if test.old != "" {
+			os.WriteFile(path, []byte(test.old), test.mode)
+			os.Chmod(path, test.mode)
+		}
+		err := writeAtomic(path, 0640, func(w io.Writer) error {
+			if test.err != nil {
+				return test.err
+			}
+			_, err := io.WriteString(w, test.fill)
+			return err
+		})
+		if err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+		content, err := os.ReadFile(path)
+		if string(content) != test.want || (test.want == "") != os.IsNotExist(err) {
+			t.Errorf("%s: file holds %q, %v", test.name, content, err)
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode() != test.wantMod {
+			t.Errorf("%s: file has mode %v, want %v", test.name, info.Mode(), test.wantMod)
+		}
+		if rest := leftovers(dir, "file"); len(rest) > 0 {
+			t.Errorf("%s: left %v behind", test.name, rest)
+		}
+	}
+}
+
+func TestWriteAtomicThroughLink(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "real"), []byte("old"), 0644)
+	os.Symlink("real", filepath.Join(dir, "link"))
+	files := Files{&File{Path: filepath.Join(dir, "link")}}
+	if err := files.Write([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if link, _ := os.Readlink(filepath.Join(dir, "link")); link != "real" {
+		t.Fatal("link replaced")
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "real")); string(content) != "new" {
+		t.Fatalf("target holds %q", content)
+	}
+}
+
+func TestWriteAppend(t *testing.T) {
+	dir := t.TempDir()
+	existing, missing := filepath.Join(dir, "existing"), filepath.Join(dir, "missing")
+	os.WriteFile(existing, []byte("one\n"), 0600)
+	before, _ := os.Stat(existing)
+	files := Files{&File{Path: existing}, &File{Path: missing}}
+	if err := files.Append([]byte("two\n")); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(existing)
+	if !os.SameFile(before, after) || after.Mode().Perm() != 0600 {
+		t.Fatal("existing file replaced instead of appended to")
+	}
+	tests := []struct {
+		path, want string
+	}{
+		{existing, "one\ntwo\n"},
+		{missing, "two\n"},
+	}
+	for _, test := range tests {
+		if content, _ := os.ReadFile(test.path); string(content) != test.want {
+			t.Errorf("%s: got %q, want %q", filepath.Base(test.path), content, test.want)
+		}
+	}
+	if err := files.Write([]byte("over")); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(existing); string(content) != "over" {
+		t.Fatalf("write gives %q", content)
+	}
+	if rest := leftovers(dir, "existing", "missing"); len(rest) > 0 {
+		t.Fatalf("left %v behind", rest)
+	}
+}
+
+func TestAppendConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	os.WriteFile(path, nil, 0644)
+	files := Files{&File{Path: path}}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(line string) {
+			defer wg.Done()
+			files.Append([]byte(line))
+		}(strings.Repeat(string(rune('a'+i)), 10) + "\n")
+	}
+	wg.Wait()
+	content, _ := os.ReadFile(path)
+	if lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n"); len(lines) != 20 {
+		t.Fatalf("got %d lines, want 20", len(lines))
+	}
+}
+
+func TestConfigSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dirk.conf")
+	os.WriteFile(path, []byte("[main]\nkey = old\n"), 0600)
+	conf := NewConfig()
+	if err := conf.Parse(path); err != nil {
+		t.Fatal(err)
+	}
+	conf.Get("main").Add("key", "new")
+	if err := conf.Save(""); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := NewConfig()
+	if err := reloaded.Parse(path); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := reloaded.Get("main").String("key"); value != "new" {
+		t.Fatalf("saved %q", value)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Fatalf("saved with mode %v", info.Mode())
+	}
+}
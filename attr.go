@@ -0,0 +1,247 @@
+package dirk
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ChangeOptions select the files Chmod and Chown change.
+type ChangeOptions struct {
+	Recursive bool // Recursive descends into selected folders.
+	FilesOnly bool // FilesOnly leaves folders alone.
+	DirsOnly  bool // DirsOnly leaves everything but folders alone.
+}
+
+// Chmod changes the permissions of the selection. The mode is octal (755,
+// 0644) or symbolic like chmod(1): a comma separated list of clauses such as
+// u+x, go-w, a=rX or g=u. A clause without u, g, o or a applies to all.
+// Symbolic links found while recursing are skipped.
+func (files Files) Chmod(mode string, options ...ChangeOptions) error {
+	change, err := parseMode(mode)
+	if err != nil {
+		return err
+	}
+	return files.change("chmod", options, func(path string, info os.FileInfo) error {
+		if info.Mode()&os.ModeSymlink != 0 {
+			return errSkip
+		}
+		return os.Chmod(path, fromUnix(change(toUnix(info.Mode()), info.IsDir())))
+	})
+}
+
+// Chown changes the owner and group of the selection. The owner is given as
+// user, user:group or :group, by name or numeric id. Symbolic links are
+// changed themselves rather than their targets.
+func (files Files) Chown(owner string, options ...ChangeOptions) error {
+	uid, gid, err := parseOwner(owner)
+	if err != nil {
+		return err
+	}
+	return files.change("chown", options, func(path string, info os.FileInfo) error {
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+// change applies fn to the selection, and below it when recursive, and
+// reports every path it considered.
+func (files Files) change(op string, options []ChangeOptions, fn func(string, os.FileInfo) error) error {
+	if len(files) == 0 {
+		return ErrNoSelection
+	}
+	var opts ChangeOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	res := &Result{Op: op}
+	halt := errors.New("halt")
+	visit := func(path string, info os.FileInfo, err error) error {
+		if err == nil {
+			if (opts.FilesOnly && info.IsDir()) || (opts.DirsOnly && !info.IsDir()) {
+				return nil
+			}
+			err = fn(path, info)
+		}
+		switch err {
+		case nil:
+			res.Succeeded = append(res.Succeeded, path)
+		case errSkip:
+			res.Skipped = append(res.Skipped, path)
+		default:
+			res.fail(op, path, err)
+			if !ContinueOnError {
+				return halt
+			}
+		}
+		return nil
+	}
+	for _, f := range files {
+		var err error
+		if opts.Recursive {
+			err = filepath.Walk(f.Path, visit)
+		} else {
+			info, lerr := os.Lstat(f.Path)
+			err = visit(f.Path, info, lerr)
+		}
+		if err == halt {
+			break
+		}
+	}
+	return res.Err()
+}
+
+func toUnix(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+func fromUnix(bits uint32) os.FileMode {
+	mode := os.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// parseMode turns an octal or symbolic mode into a function computing the new
+// permission bits from the current ones.
+func parseMode(spec string) (func(bits uint32, dir bool) uint32, error) {
+	if octal, err := strconv.ParseUint(spec, 8, 32); err == nil {
+		if octal > 07777 {
+			return nil, fmt.Errorf("invalid mode %q", spec)
+		}
+		return func(uint32, bool) uint32 { return uint32(octal) }, nil
+	}
+	type clause struct {
+		who   uint32
+		op    byte
+		perms string
+	}
+	var clauses []clause
+	for _, part := range strings.Split(spec, ",") {
+		var who uint32
+		i := 0
+	Who:
+		for ; i < len(part); i++ {
+			switch part[i] {
+			case 'u':
+				who |= 04700
+			case 'g':
+				who |= 02070
+			case 'o':
+				who |= 01007
+			case 'a':
+				who |= 07777
+			default:
+				break Who
+			}
+		}
+		if who == 0 {
+			who = 07777
+		}
+		if i == len(part) {
+			return nil, fmt.Errorf("invalid mode %q", spec)
+		}
+		for i < len(part) {
+			op := part[i]
+			if op != '+' && op != '-' && op != '=' {
+				return nil, fmt.Errorf("invalid mode %q", spec)
+			}
+			j := i + 1
+			for j < len(part) && strings.IndexByte("rwxXstugo", part[j]) >= 0 {
+				j++
+			}
+			clauses = append(clauses, clause{who, op, part[i+1 : j]})
+			i = j
+		}
+	}
+	return func(bits uint32, dir bool) uint32 {
+		for _, c := range clauses {
+			var perms uint32
+			for k := 0; k < len(c.perms); k++ {
+				switch c.perms[k] {
+				case 'r':
+					perms |= 0444
+				case 'w':
+					perms |= 0222
+				case 'x':
+					perms |= 0111
+				case 'X':
+					if dir || bits&0111 != 0 {
+						perms |= 0111
+					}
+				case 's':
+					perms |= 06000
+				case 't':
+					perms |= 01000
+				case 'u':
+					perms |= (bits >> 6 & 7) * 0111
+				case 'g':
+					perms |= (bits >> 3 & 7) * 0111
+				case 'o':
+					perms |= (bits & 7) * 0111
+				}
+			}
+			perms &= c.who
+			switch c.op {
+			case '+':
+				bits |= perms
+			case '-':
+				bits &^= perms
+			case '=':
+				bits = bits&^(c.who&0777) | perms
+			}
+		}
+		return bits
+	}, nil
+}
+
+// parseOwner resolves user:group into ids, -1 standing for no change.
+func parseOwner(owner string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	name, group := owner, ""
+	if i := strings.IndexByte(owner, ':'); i >= 0 {
+		name, group = owner[:i], owner[i+1:]
+	}
+	if name != "" {
+		if uid, err = strconv.Atoi(name); err != nil {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return -1, -1, err
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return -1, -1, err
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	if uid == -1 && gid == -1 {
+		return -1, -1, fmt.Errorf("invalid owner %q", owner)
+	}
+	return uid, gid, nil
+}
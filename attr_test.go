@@ -0,0 +1,137 @@
+package dirk
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		spec string
+		bits uint32
+		dir  bool
+		want uint32
+	}{
+		{"755", 0, false, 0755},
+		{"0644", 0777, false, 0644},
+		{"4755", 0, false, 04755},
+		{"u+x", 0644, false, 0744},
+		{"u+x,go-w", 0666, false, 0744},
+		{"+x", 0644, false, 0755},
+		{"a-w", 0777, false, 0555},
+		{"o=", 0777, false, 0770},
+		{"u=rw,g=r,o=", 0777, false, 0640},
+		{"a=rX", 0700, true, 0555},
+		{"a=rX", 0600, false, 0444},
+		{"a=rX", 0700, false, 0555},
+		{"g=u", 0740, false, 0770},
+		{"o=g", 0750, false, 0755},
+		{"u+s", 0755, false, 04755},
+		{"g+s", 0755, true, 02755},
+		{"+t", 0755, true, 01755},
+		{"u-s", 04755, false, 0755},
+		{"u+x-w", 0644, false, 0544},
+		{"u+", 0644, false, 0644},
+	}
+	for _, test := range tests {
+		change, err := parseMode(test.spec)
+		if err != nil {
+			t.Errorf("%s: %v", test.spec, err)
+			continue
+		}
+		if got := change(test.bits, test.dir); got != test.want {
+			t.Errorf("%s on %04o: got %04o, want %04o", test.spec, test.bits, got, test.want)
+		}
+	}
+}
+
+func TestParseModeInvalid(t *testing.T) {
+	for _, spec := range []string{"", "u", "ug", "u*x", "ux", "99999", "10000", "u+x,", ",u+x", "u+x,g"} {
+		if _, err := parseMode(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	tests := []struct {
+		owner    string
+		uid, gid int
+		ok       bool
+	}{
+		{"1000", 1000, -1, true},
+		{"1000:100", 1000, 100, true},
+		{":100", -1, 100, true},
+		{"1000.100", -1, -1, false}, // a dot belongs to the user name
+		{"0:0", 0, 0, true},
+		{"", -1, -1, false},
+		{":", -1, -1, false},
+		{"no-such-user-here", -1, -1, false},
+		{"0:no-such-group-here", -1, -1, false},
+	}
+	for _, test := range tests {
+		uid, gid, err := parseOwner(test.owner)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v", test.owner, err)
+			continue
+		}
+		if uid != test.uid || gid != test.gid {
+			t.Errorf("%q: got %d:%d, want %d:%d", test.owner, uid, gid, test.uid, test.gid)
+		}
+	}
+}
+
+func TestParseOwnerDottedName(t *testing.T) {
+	_, _, err := parseOwner("no.such.user:0")
+	if unknown, ok := err.(user.UnknownUserError); !ok || string(unknown) != "no.such.user" {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestChmodRecursive(t *testing.T) {
+	tests := []struct {
+		name            string
+		options         ChangeOptions
+		root, dir, file os.FileMode
+	}{
+		{"selection only", ChangeOptions{}, 0700, 0755, 0644},
+		{"recursive", ChangeOptions{Recursive: true}, 0700, 0700, 0600},
+		{"folders only", ChangeOptions{Recursive: true, DirsOnly: true}, 0700, 0700, 0644},
+		{"files only", ChangeOptions{Recursive: true, FilesOnly: true}, 0755, 0755, 0600},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), "root")
+			os.MkdirAll(filepath.Join(root, "dir"), 0755)
+			os.WriteFile(filepath.Join(root, "dir", "file"), nil, 0644)
+			os.Symlink("dir/file", filepath.Join(root, "link"))
+			files, _ := MakeFiles([]string{root})
+			if err := files.Chmod("go-rwx", test.options); err != nil {
+				t.Fatal(err)
+			}
+			for path, want := range map[string]os.FileMode{"": test.root, "dir": test.dir, "dir/file": test.file} {
+				info, _ := os.Stat(filepath.Join(root, path))
+				if info.Mode().Perm() != want {
+					t.Errorf("%s: got %v, want %v", path, info.Mode().Perm(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestChown(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	os.WriteFile(path, nil, 0644)
+	files, _ := MakeFiles([]string{path})
+	owner := strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid())
+	if err := files.Chown(owner); err != nil {
+		t.Fatal(err)
+	}
+	if err := files.Chown("no-such-user-here"); err == nil {
+		t.Fatal("chown to an unknown user")
+	}
+}
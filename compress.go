@@ -0,0 +1,167 @@
+package dirk
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	bzip2w "github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Archive kinds are "zip", "tar", a compressed tar such as "tar.xz", or a
+// single compressed file: "gz", "bz2", "xz" or "zst".
+var archiveSuffixes = []struct{ suffix, kind string }{
+	{".zip", "zip"},
+	{".tar", "tar"},
+	{".tar.gz", "tar.gz"}, {".tgz", "tar.gz"},
+	{".tar.bz2", "tar.bz2"}, {".tbz2", "tar.bz2"}, {".tbz", "tar.bz2"},
+	{".tar.xz", "tar.xz"}, {".txz", "tar.xz"},
+	{".tar.zst", "tar.zst"}, {".tzst", "tar.zst"},
+	{".gz", "gz"}, {".bz2", "bz2"}, {".xz", "xz"}, {".zst", "zst"},
+}
+
+// compressions maps the MIME types of compressed files to their kind.
+var compressions = map[string]string{
+	"application/gzip":    "gz",
+	"application/x-bzip2": "bz2",
+	"application/x-xz":    "xz",
+	"application/zstd":    "zst",
+}
+
+// archiveKind names the archive format of path from its extension.
+func archiveKind(path string) string {
+	for _, s := range archiveSuffixes {
+		if strings.HasSuffix(path, s.suffix) {
+			return s.kind
+		}
+	}
+	return ""
+}
+
+// archiveSuffix returns the extension archiveKind recognised in path.
+func archiveSuffix(path string) string {
+	for _, s := range archiveSuffixes {
+		if strings.HasSuffix(path, s.suffix) {
+			return s.suffix
+		}
+	}
+	return ""
+}
+
+// detectArchive works out the format of an archive from its content, so
+// misnamed archives are recognised, and from its name otherwise.
+func detectArchive(path string) string {
+	mime, _, err := DetectFile(path)
+	if err != nil {
+		return ""
+	}
+	if kind := mimeKind(mime); kind != "" {
+		return kind
+	}
+	if compression, ok := compressions[mime]; ok {
+		if isCompressedTar(path, compression) {
+			return "tar." + compression
+		}
+		return compression
+	}
+	return archiveKind(path)
+}
+
+// mimeKind names the archive kind of an uncompressed archive MIME type.
+func mimeKind(mime string) string {
+	switch mime {
+	case "application/zip":
+		return "zip"
+	case "application/x-tar":
+		return "tar"
+	}
+	return ""
+}
+
+// splitKind tells whether an archive kind holds a tar and which
+// compression it uses.
+func splitKind(kind string) (tarred bool, compression string) {
+	switch {
+	case kind == "zip":
+		return false, ""
+	case kind == "tar":
+		return true, ""
+	case strings.HasPrefix(kind, "tar."):
+		return true, strings.TrimPrefix(kind, "tar.")
+	}
+	return false, kind
+}
+
+func isCompressedTar(path, compression string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	reader, err := decompressor(compression, file)
+	if err != nil {
+		return false
+	}
+	defer reader.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(reader, head)
+	return match_Tar(head[:n])
+}
+
+// decompressor reads the content of a compressed stream.
+func decompressor(compression string, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case "gz":
+		return gzip.NewReader(r)
+	case "bz2":
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case "xz":
+		reader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(reader), nil
+	case "zst":
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, errors.New("unknown compression " + compression)
+}
+
+// compressor compresses what is written to it into w at level, from 1 for
+// the fastest to 9 for the smallest, zero being the default of the format.
+// xz has a single level. Closing it flushes
+// the stream but leaves w open.
+func compressor(compression string, w io.Writer, level int) (io.WriteCloser, error) {
+	if level < 0 || level > 9 {
+		return nil, fmt.Errorf("compression level %d is not between 1 and 9", level)
+	}
+	switch compression {
+	case "gz":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case "bz2":
+		// the standard library only reads bzip2
+		return bzip2w.NewWriter(w, &bzip2w.WriterConfig{Level: level})
+	case "xz":
+		return xz.NewWriter(w)
+	case "zst":
+		if level == 0 {
+			return zstd.NewWriter(w)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	return nil, errors.New("unknown compression " + compression)
+}
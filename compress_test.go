@@ -0,0 +1,140 @@
+package dirk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+// tarBz2 is a tar of src/a.txt holding "hello world", compressed by bzip2,
+// and oneBz2 is "single content" compressed on its own.
+const (
+	tarBz2 = "QlpoOTFBWSZTWVF8Ac4AAKz7kMqAAkBAAf+AAIRuRJ7ABAAACCAAdBJTVNqepoaHqep6npqHpHlB" +
+		"JRDQaA0AAD7pFD7XEiQGkqQCvhIMc0Hw5GlBMIQwE5K1fRJ4hGtYExwKrwnqmjlnHvVoZs5HmhIt" +
+		"bG7bIYL0Ch+GmeDbjceGIF8FIyEg/i7kinChIKL4A5w="
+	oneBz2 = "QlpoOTFBWSZTWbeyzTwAAAWRgEAACqWMACAAMQAwIAeppMYhHLkDeLuSKcKEhb2WaeA="
+)
+
+func TestArchiveKind(t *testing.T) {
+	tests := map[string]string{
+		"a.zip":     "zip",
+		"a.tar":     "tar",
+		"a.tar.gz":  "tar.gz",
+		"a.tgz":     "tar.gz",
+		"a.tar.bz2": "tar.bz2",
+		"a.tbz":     "tar.bz2",
+		"a.tar.xz":  "tar.xz",
+		"a.tzst":    "tar.zst",
+		"a.txt.gz":  "gz",
+		"a.bz2":     "bz2",
+		"a.xz":      "xz",
+		"a.zst":     "zst",
+		"a.rar":     "",
+		"a.txt":     "",
+	}
+	for name, want := range tests {
+		if kind := archiveKind(name); kind != want {
+			t.Errorf("%s: got %q, want %q", name, kind, want)
+		}
+	}
+}
+
+func TestArchiveFormats(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	os.WriteFile(filepath.Join(src, "sub", "a.txt"), []byte("hello world"), 0644)
+	single := filepath.Join(dir, "one.txt")
+	os.WriteFile(single, []byte("single content"), 0644)
+	tests := []struct {
+		name    string
+		sources []string
+		member  string
+		content string
+	}{
+		{"out.zip", []string{src}, "src/sub/a.txt", "hello world"},
+		{"out.tar", []string{src}, "src/sub/a.txt", "hello world"},
+		{"out.tar.gz", []string{src}, "src/sub/a.txt", "hello world"},
+		{"out.tgz", []string{src}, "src/sub/a.txt", "hello world"},
+		{"out.tar.bz2", []string{src}, "src/sub/a.txt", "hello world"},
+		{"out.tar.xz", []string{src}, "src/sub/a.txt", "hello world"},
+		{"out.tar.zst", []string{src}, "src/sub/a.txt", "hello world"},
+		{"one.txt.gz", []string{single}, "one.txt", "single content"},
+		{"one.txt.bz2", []string{single}, "one.txt", "single content"},
+		{"one.txt.xz", []string{single}, "one.txt", "single content"},
+		{"one.txt.zst", []string{single}, "one.txt", "single content"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := filepath.Join(dir, test.name)
+			if err := archive(test.sources, target, nil); err != nil {
+				t.Fatal(err)
+			}
+			// a misnamed copy is recognised by its content
+			misnamed := filepath.Join(t.TempDir(), path.Base(test.member)+".bin")
+			data, _ := os.ReadFile(target)
+			os.WriteFile(misnamed, data, 0644)
+			if kind := detectArchive(misnamed); kind != archiveKind(target) {
+				t.Fatalf("detected %q, want %q", kind, archiveKind(target))
+			}
+			out := filepath.Join(t.TempDir(), "out")
+			if err := extract(misnamed, out); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(test.member)))
+			if err != nil || string(content) != test.content {
+				t.Fatalf("got %q, %v", content, err)
+			}
+		})
+	}
+}
+
+func TestArchiveSingleFileOnly(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	os.WriteFile(a, nil, 0644)
+	os.WriteFile(b, nil, 0644)
+	files, _ := MakeFiles([]string{a, b})
+	plan, err := files.ArchivePlan("two.xz")
+	if err != nil || plan.Actions[0].Conflict == "" {
+		t.Fatalf("xz of two files planned without conflict: %v", err)
+	}
+	if _, err := files.ArchivePlan("two.rar"); err == nil {
+		t.Fatal("rar planned")
+	}
+}
+
+// TestBzip2 reads streams written by the bzip2 tool rather than by dirk.
+func TestBzip2(t *testing.T) {
+	dir := t.TempDir()
+	files, _ := MakeFiles([]string{dir})
+	if plan, _ := files.ArchivePlan("out.tar.bz2"); plan.Actions[0].Conflict != "" {
+		t.Errorf("tar.bz2 planned with conflict %q", plan.Actions[0].Conflict)
+	}
+
+	tarball, _ := base64.StdEncoding.DecodeString(tarBz2)
+	misnamed := filepath.Join(dir, "archive.bin")
+	os.WriteFile(misnamed, tarball, 0644)
+	if kind := detectArchive(misnamed); kind != "tar.bz2" {
+		t.Fatalf("detected %q", kind)
+	}
+	if err := extract(misnamed, filepath.Join(dir, "tar")); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "tar", "src", "a.txt")); string(content) != "hello world" {
+		t.Fatalf("got %q", content)
+	}
+
+	single, _ := base64.StdEncoding.DecodeString(oneBz2)
+	r, err := decompressor("bz2", bytes.NewReader(single))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := io.ReadAll(r); string(content) != "single content" {
+		t.Fatalf("got %q", content)
+	}
+}
@@ -198,13 +198,19 @@ func (c *Config) Save(file string) error {
 	return c.saveFile(file)
 }
 
-// saveFile save config info in specified file.
+// saveFile save config info in specified file, replacing it atomically.
 func (c *Config) saveFile(file string) error {
-	f, err := os.OpenFile(file, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	return writeAtomic(file, 0644, func(w io.Writer) error {
+		f := bufio.NewWriter(w)
+		if err := c.write(f); err != nil {
+			return err
+		}
+		return f.Flush()
+	})
+}
+
+// write write config info to f.
+func (c *Config) write(f *bufio.Writer) error {
 	// sections
 	for _, section := range c.dataOrder {
 		data, _ := c.data[section]
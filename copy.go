@@ -0,0 +1,50 @@
+package dirk
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// ReflinkMode controls whether file copies may share extents with their
+// source through a copy-on-write clone.
+type ReflinkMode int
+
+const (
+	// ReflinkAuto clones when the filesystem supports it and falls back to
+	// copy_file_range or a buffered copy otherwise.
+	ReflinkAuto ReflinkMode = iota
+	// ReflinkAlways fails the copy when a clone cannot be made.
+	ReflinkAlways
+	// ReflinkNever always copies the data.
+	ReflinkNever
+)
+
+// Reflink selects the clone behaviour of Paste, Move and the other copying
+// operations.
+var Reflink = ReflinkAuto
+
+var errNoReflink = errors.New("reflink not supported")
+
+// copyData copies the contents of in to out, trying a reflink clone, then
+// an in-kernel copy_file_range and finally a buffered user-space copy.
+func copyData(out, in *os.File, tr *tracker) error {
+	if Reflink != ReflinkNever {
+		err := reflink(out, in)
+		if err == nil {
+			if info, err := in.Stat(); err == nil {
+				tr.add(info.Size())
+			}
+			return nil
+		}
+		if Reflink == ReflinkAlways {
+			return err
+		}
+	}
+	if done, err := copyRange(out, in, tr); done || err != nil {
+		return err
+	}
+	// hide the *os.File types so io.Copy does not retry the kernel paths
+	_, err := io.Copy(struct{ io.Writer }{out}, tr.reader(struct{ io.Reader }{in}))
+	return err
+}
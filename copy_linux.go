@@ -0,0 +1,50 @@
+//go:build linux
+// +build linux
+
+package dirk
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxCopyRange bounds a single copy_file_range call so progress can be reported
+// between chunks.
+const maxCopyRange = 8 << 20
+
+func reflink(out, in *os.File) error {
+	err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	switch err {
+	case nil:
+		return nil
+	case unix.EOPNOTSUPP, unix.ENOTTY, unix.EXDEV, unix.EINVAL, unix.ENOSYS:
+		return errNoReflink
+	}
+	return err
+}
+
+// copyRange copies in to out with copy_file_range. It reports done as false
+// when the kernel or filesystem cannot finish the copy, leaving both file
+// offsets where the caller can resume with a plain copy.
+func copyRange(out, in *os.File, tr *tracker) (done bool, err error) {
+	written := false
+	for {
+		n, err := unix.CopyFileRange(int(in.Fd()), nil, int(out.Fd()), nil, maxCopyRange, 0)
+		switch err {
+		case nil:
+		case unix.EINTR:
+			continue
+		case unix.ENOSYS, unix.EXDEV, unix.EINVAL, unix.EOPNOTSUPP, unix.EPERM:
+			return false, nil
+		default:
+			return false, err
+		}
+		if n == 0 {
+			// pseudo filesystems report zero length, let io.Copy have a go
+			return written, nil
+		}
+		written = true
+		tr.add(int64(n))
+	}
+}
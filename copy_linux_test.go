@@ -0,0 +1,80 @@
+package dirk
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyData(t *testing.T) {
+	defer func(mode ReflinkMode) { Reflink = mode }(Reflink)
+	defer func(fn ProgressFunc) { OnProgress = fn }(OnProgress)
+	dir := t.TempDir()
+	data := make([]byte, 3*maxCopyRange+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	sources := map[string][]byte{
+		"empty": nil,
+		"small": []byte("hello"),
+		"large": data,
+	}
+	for name, content := range sources {
+		os.WriteFile(filepath.Join(dir, name), content, 0640)
+	}
+	// procfs reports a size of zero, so copy_file_range copies nothing
+	proc, _ := os.ReadFile("/proc/self/limits")
+	sources["/proc/self/limits"] = proc
+	for _, mode := range []ReflinkMode{ReflinkAuto, ReflinkNever} {
+		for name, content := range sources {
+			src := name
+			if !filepath.IsAbs(src) {
+				src = filepath.Join(dir, name)
+			}
+			var last ProgressEvent
+			OnProgress = func(ev ProgressEvent) { last = ev }
+			Reflink = mode
+			dst := filepath.Join(t.TempDir(), "copy")
+			tr := newTracker("copy")
+			if err := cpFile(src, dst, tr); err != nil {
+				t.Fatalf("%s in mode %d: %v", name, mode, err)
+			}
+			tr.finish()
+			got, _ := os.ReadFile(dst)
+			if name == "/proc/self/limits" {
+				// the content is generated for every reader
+				if len(got) == 0 {
+					t.Errorf("%s in mode %d: copied nothing", name, mode)
+				}
+				continue
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("%s in mode %d: copied %d bytes of %d", name, mode, len(got), len(content))
+			}
+			if last.BytesDone != int64(len(content)) || last.FilesDone != 1 {
+				t.Errorf("%s in mode %d: counted %d bytes and %d files", name, mode, last.BytesDone, last.FilesDone)
+			}
+			if info, _ := os.Stat(dst); info.Mode().Perm() != 0640 {
+				t.Errorf("%s in mode %d: copied with mode %v", name, mode, info.Mode())
+			}
+		}
+	}
+}
+
+func TestCopyReflinkAlways(t *testing.T) {
+	defer func(mode ReflinkMode) { Reflink = mode }(Reflink)
+	Reflink = ReflinkAlways
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	os.WriteFile(src, []byte("content"), 0644)
+	// without clones the copy fails instead of quietly copying the data
+	switch err := cpFile(src, dst, nil); err {
+	case nil:
+		if got, _ := os.ReadFile(dst); string(got) != "content" {
+			t.Fatalf("cloned %q", got)
+		}
+	case errNoReflink:
+	default:
+		t.Fatal(err)
+	}
+}
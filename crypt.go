@@ -2,6 +2,7 @@
 package dirk
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
@@ -9,104 +10,417 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha1"
-	"encoding/hex"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"hash"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
 )
 
-func Encrypt(source string, password []byte) {
+// Encrypted files start with a header: the magic "DIRKENC", the format
+// version, the KDF and its three parameters, the random salt of the KDF and
+// the chunk size.
+// The content follows in chunks of AES-256-GCM, each of chunkSize bytes of
+// plaintext but the last, which may be shorter. The nonce of a chunk is its
+// counter and a flag set on the last chunk only, so chunks cannot be
+// reordered, dropped or cut off, and every chunk authenticates the header.
+//
+// Files without the magic are taken for the legacy format: a single GCM
+// message followed by its nonce, which was also the salt of PBKDF2-HMAC-SHA1
+// at 4096 iterations.
+const (
+	cryptMagic   = "DIRKENC"
+	cryptVersion = 1
+	chunkSize    = 64 * 1024
+	saltSize     = 16
+	headerSize   = len(cryptMagic) + 1 + 1 + 3*4 + saltSize + 4
+)
 
-	if _, err := os.Stat(source); os.IsNotExist(err) {
-		panic(err.Error())
-	}
+const (
+	kdfPBKDF2   = 1 // kdfPBKDF2 is PBKDF2-HMAC-SHA256: iterations
+	kdfScrypt   = 2 // kdfScrypt is scrypt: log2 of N, r and p
+	kdfArgon2id = 3 // kdfArgon2id is Argon2id: passes, memory in KiB and threads
+)
+
+// KDF is a password key derivation and its parameters, which are stored in
+// the header of every encrypted file.
+type KDF struct {
+	id     byte
+	params [3]uint32
+}
 
-	plaintext, err := ioutil.ReadFile(source)
+// Argon2id derives keys with Argon2id over time passes of memory KiB,
+// using threads threads. Up to 10 passes over 1 GiB are allowed.
+func Argon2id(time, memory uint32, threads uint8) KDF {
+	return KDF{kdfArgon2id, [3]uint32{time, memory, uint32(threads)}}
+}
 
-	if err != nil {
-		panic(err.Error())
+// Scrypt derives keys with scrypt, at a cost N of 1<<logN. logN may be
+// up to 20, r up to 32 and p up to 16, as long as the 128*r*N bytes it
+// takes stay within 1 GiB.
+func Scrypt(logN, r, p int) KDF {
+	return KDF{kdfScrypt, [3]uint32{uint32(logN), uint32(r), uint32(p)}}
+}
+
+// PBKDF2 derives keys with PBKDF2-HMAC-SHA256, over up to 10000000
+// iterations.
+func PBKDF2(iterations int) KDF {
+	return KDF{kdfPBKDF2, [3]uint32{uint32(iterations)}}
+}
+
+// MaxLegacySize caps the size of files in the legacy format, which are read
+// into memory whole to be decrypted.
+var MaxLegacySize int64 = 1 << 30
+
+// KeyDerivation is the KDF of newly encrypted files. Files keep the KDF
+// they were encrypted with, so changing it never stops them decrypting.
+var KeyDerivation = Argon2id(3, 64*1024, 4)
+
+// derive makes a key of 32 bytes from password and salt. The parameters
+// come from the header of the file being decrypted, so they are capped to
+// keep a crafted header from taking hours of CPU or all the memory.
+func (k KDF) derive(password, salt []byte) ([]byte, error) {
+	p := k.params
+	switch k.id {
+	case kdfPBKDF2:
+		if p[0] == 0 || p[0] > 10000000 {
+			return nil, fmt.Errorf("PBKDF2 iterations %d out of range", p[0])
+		}
+		return Key(password, salt, int(p[0]), 32, sha256.New), nil
+	case kdfScrypt:
+		// scrypt takes 128*r*N bytes, up to 1 GiB
+		if p[0] == 0 || p[0] > 20 || p[1] == 0 || p[1] > 32 || p[2] == 0 || p[2] > 16 || 128*uint64(p[1])<<p[0] > 1<<30 {
+			return nil, fmt.Errorf("scrypt parameters %v out of range", p)
+		}
+		return scrypt.Key(password, salt, 1<<p[0], int(p[1]), int(p[2]), 32)
+	case kdfArgon2id:
+		// memory is in KiB, up to 1 GiB
+		if p[0] == 0 || p[0] > 10 || p[1] < 8 || p[1] > 1<<20 || p[2] == 0 || p[2] > 255 {
+			return nil, fmt.Errorf("Argon2id parameters %v out of range", p)
+		}
+		return argon2.IDKey(password, salt, p[0], p[1], uint8(p[2]), 32), nil
 	}
+	return nil, fmt.Errorf("unknown key derivation %d", k.id)
+}
 
-	key := password
-	nonce := make([]byte, 12)
+// ErrDecrypt is returned when encrypted data does not authenticate, either
+// because the password is wrong or because the data was damaged.
+var ErrDecrypt = errors.New("wrong password or damaged data")
 
-	// Randomizing the nonce
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		panic(err.Error())
+var (
+	errNotEncrypted = errors.New("not an encrypted file")
+	errLegacy       = errors.New("legacy encryption format")
+	errLegacySize   = errors.New("legacy encrypted file larger than MaxLegacySize")
+)
+
+// cryptHeader is the header of an encrypted stream.
+type cryptHeader struct {
+	kdf   KDF
+	salt  []byte
+	chunk uint32
+}
+
+func (h cryptHeader) marshal() []byte {
+	buf := make([]byte, 0, headerSize)
+	buf = append(buf, cryptMagic...)
+	buf = append(buf, cryptVersion, h.kdf.id)
+	for _, p := range h.kdf.params {
+		buf = binary.BigEndian.AppendUint32(buf, p)
 	}
+	buf = append(buf, h.salt...)
+	return binary.BigEndian.AppendUint32(buf, h.chunk)
+}
 
-	dk := Key(key, nonce, 4096, 32, sha1.New)
+// readCryptHeader reads the header of an encrypted stream. Without the
+// magic it returns errLegacy with the bytes it read.
+func readCryptHeader(r io.Reader) (cryptHeader, []byte, error) {
+	buf := make([]byte, headerSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return cryptHeader{}, nil, err
+	}
+	if n < len(cryptMagic) || string(buf[:len(cryptMagic)]) != cryptMagic {
+		return cryptHeader{}, buf[:n], errLegacy
+	} else if n < headerSize {
+		return cryptHeader{}, nil, io.ErrUnexpectedEOF
+	}
+	rest := buf[len(cryptMagic):]
+	if rest[0] != cryptVersion {
+		return cryptHeader{}, nil, fmt.Errorf("encryption format version %d is not supported", rest[0])
+	}
+	h := cryptHeader{kdf: KDF{id: rest[1]}}
+	rest = rest[2:]
+	for i := range h.kdf.params {
+		h.kdf.params[i] = binary.BigEndian.Uint32(rest)
+		rest = rest[4:]
+	}
+	h.salt = rest[:saltSize]
+	h.chunk = binary.BigEndian.Uint32(rest[saltSize:])
+	if h.chunk == 0 || h.chunk > 16<<20 {
+		return cryptHeader{}, nil, fmt.Errorf("bad chunk size %d", h.chunk)
+	}
+	return h, buf, nil
+}
 
-	block, err := aes.NewCipher(dk)
+func (h cryptHeader) aead(password []byte) (cipher.AEAD, error) {
+	key, err := h.kdf.derive(password, h.salt)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-
-	aesgcm, err := cipher.NewGCM(block)
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
 
-	ciphertext := aesgcm.Seal(nil, nonce, plaintext, nil)
+// chunkNonce is the nonce of chunk number counter.
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
 
-	// Append the nonce to the end of file
-	ciphertext = append(ciphertext, nonce...)
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	buf     []byte
+	counter uint64
+	closed  bool
+}
 
-	f, err := os.Create(source)
-	if err != nil {
-		panic(err.Error())
+// NewEncryptWriter returns a writer encrypting what is written to it into
+// w, with a key derived from password and a random salt by KeyDerivation.
+// Close seals the last chunk; it does not close w.
+func NewEncryptWriter(w io.Writer, password []byte) (io.WriteCloser, error) {
+	h := cryptHeader{
+		kdf:   KeyDerivation,
+		salt:  make([]byte, saltSize),
+		chunk: chunkSize,
+	}
+	if _, err := io.ReadFull(rand.Reader, h.salt); err != nil {
+		return nil, err
 	}
-	_, err = io.Copy(f, bytes.NewReader(ciphertext))
+	aead, err := h.aead(password)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
+	header := h.marshal()
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, header: header, buf: make([]byte, 0, chunkSize)}, nil
 }
 
-func Decrypt(source string, password []byte) {
-
-	if _, err := os.Stat(source); os.IsNotExist(err) {
-		panic(err.Error())
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to a closed encrypt writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more follows, the last one is
+		// sealed by Close
+		if len(e.buf) == chunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
 	}
+	return written, nil
+}
 
-	ciphertext, err := ioutil.ReadFile(source)
+func (e *encryptWriter) seal(last bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.counter, last), e.buf, e.header)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
 
-	if err != nil {
-		panic(err.Error())
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
 	}
+	e.closed = true
+	return e.seal(true)
+}
 
-	key := password
-	salt := ciphertext[len(ciphertext)-12:]
-	str := hex.EncodeToString(salt)
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	chunk   int
+	buf     []byte // buf holds plaintext not read yet
+	counter uint64
+	done    bool
+}
 
-	nonce, err := hex.DecodeString(str)
+// NewDecryptReader returns a reader of the plaintext of the encrypted stream
+// r. Chunks are authenticated before any of their plaintext is returned;
+// ErrDecrypt is returned for a wrong password or damaged data, and
+// io.ErrUnexpectedEOF when the stream is cut short. A stream in the legacy
+// format is read and authenticated whole first, up to MaxLegacySize bytes.
+func NewDecryptReader(r io.Reader, password []byte) (io.Reader, error) {
+	h, header, err := readCryptHeader(r)
+	if err == errLegacy {
+		return decryptLegacy(io.MultiReader(bytes.NewReader(header), r), password)
+	} else if err != nil {
+		return nil, err
+	}
+	aead, err := h.aead(password)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead, header: header, chunk: int(h.chunk)}, nil
+}
 
-	dk := Key(key, nonce, 4096, 32, sha1.New)
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
 
-	block, err := aes.NewCipher(dk)
+func (d *decryptReader) open() error {
+	sealed := make([]byte, d.chunk+d.aead.Overhead())
+	n, err := io.ReadFull(d.r, sealed)
+	switch {
+	case err == io.EOF:
+		// the last chunk was never seen
+		return io.ErrUnexpectedEOF
+	case err == io.ErrUnexpectedEOF:
+		// only the last chunk is short
+		plain, err := d.aead.Open(nil, chunkNonce(d.counter, true), sealed[:n], d.header)
+		if err != nil {
+			return ErrDecrypt
+		}
+		d.buf, d.done = plain, true
+		return nil
+	case err != nil:
+		return err
+	}
+	plain, err := d.aead.Open(nil, chunkNonce(d.counter, false), sealed, d.header)
 	if err != nil {
-		panic(err.Error())
+		// a full chunk may be the last one
+		plain, err = d.aead.Open(nil, chunkNonce(d.counter, true), sealed, d.header)
+		if err != nil {
+			return ErrDecrypt
+		}
+		if n, _ := io.ReadFull(d.r, make([]byte, 1)); n > 0 {
+			return ErrDecrypt
+		}
+		d.done = true
 	}
+	d.counter++
+	d.buf = plain
+	return nil
+}
 
+// decryptLegacy opens a message of the legacy format.
+func decryptLegacy(r io.Reader, password []byte) (io.Reader, error) {
+	ciphertext, err := ioutil.ReadAll(io.LimitReader(r, MaxLegacySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(ciphertext)) > MaxLegacySize {
+		return nil, errLegacySize
+	}
+	if len(ciphertext) < 12+16 {
+		return nil, errNotEncrypted
+	}
+	nonce := ciphertext[len(ciphertext)-12:]
+	block, err := aes.NewCipher(Key(password, nonce, 4096, 32, sha1.New))
+	if err != nil {
+		return nil, err
+	}
 	aesgcm, err := cipher.NewGCM(block)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-
 	plaintext, err := aesgcm.Open(nil, nonce, ciphertext[:len(ciphertext)-12], nil)
 	if err != nil {
-		panic(err.Error())
+		return nil, ErrDecrypt
 	}
+	return bytes.NewReader(plaintext), nil
+}
 
-	f, err := os.Create(source)
+// Encrypt replaces the file at source with its encryption under password,
+// reading and writing it in chunks. The file is replaced atomically.
+func Encrypt(source string, password []byte) error {
+	tr := newTracker("encrypt", source)
+	defer tr.finish()
+	tr.file(source)
+	in, err := os.Open(source)
 	if err != nil {
-		panic(err.Error())
+		return err
+	}
+	defer in.Close()
+	err = writeAtomic(source, 0600, func(w io.Writer) error {
+		buffered := bufio.NewWriter(w)
+		encrypted, err := NewEncryptWriter(buffered, password)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(encrypted, tr.reader(in)); err != nil {
+			return err
+		}
+		if err := encrypted.Close(); err != nil {
+			return err
+		}
+		return buffered.Flush()
+	})
+	if err == nil {
+		tr.fileDone()
 	}
-	_, err = io.Copy(f, bytes.NewReader(plaintext))
+	return err
+}
+
+// Decrypt replaces the encrypted file at source with its plaintext, in the
+// current or the legacy format. Nothing is replaced unless the whole file
+// authenticates.
+func Decrypt(source string, password []byte) error {
+	tr := newTracker("decrypt", source)
+	defer tr.finish()
+	tr.file(source)
+	in, err := os.Open(source)
 	if err != nil {
-		panic(err.Error())
+		return err
+	}
+	defer in.Close()
+	err = writeAtomic(source, 0600, func(w io.Writer) error {
+		plain, err := NewDecryptReader(bufio.NewReader(tr.reader(in)), password)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, plain)
+		return err
+	})
+	if err == nil {
+		tr.fileDone()
 	}
+	return err
 }
 
 func Key(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
@@ -0,0 +1,246 @@
+package dirk
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testPassword = []byte("secret")
+
+// encryptTest encrypts plain with a fast key derivation, in writes of
+// random sizes.
+func encryptTest(t *testing.T, plain []byte) []byte {
+	defer func(k KDF) { KeyDerivation = k }(KeyDerivation)
+	KeyDerivation = PBKDF2(1000)
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := rand.New(rand.NewSource(int64(len(plain))))
+	for rest := plain; len(rest) > 0; {
+		n := 1 + random.Intn(100000)
+		if n > len(rest) {
+			n = len(rest)
+		}
+		if _, err := w.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decryptTest(encrypted, password []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(encrypted), password)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize, 3*chunkSize + 77} {
+		plain := make([]byte, size)
+		rand.New(rand.NewSource(1)).Read(plain)
+		encrypted := encryptTest(t, plain)
+		chunks := (size + chunkSize - 1) / chunkSize
+		if chunks == 0 {
+			chunks = 1
+		}
+		if len(encrypted) != headerSize+size+16*chunks {
+			t.Errorf("%d bytes: encrypted to %d", size, len(encrypted))
+		}
+		got, err := decryptTest(encrypted, testPassword)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes: got %d bytes, %v", size, len(got), err)
+		}
+		if _, err := decryptTest(encrypted, []byte("wrong")); err != ErrDecrypt {
+			t.Errorf("%d bytes: wrong password gives %v", size, err)
+		}
+	}
+}
+
+func TestDecryptDamaged(t *testing.T) {
+	plain := make([]byte, 3*chunkSize+77)
+	rand.New(rand.NewSource(1)).Read(plain)
+	encrypted := encryptTest(t, plain)
+	sealed := chunkSize + 16
+	chunk := func(i int) []byte { return encrypted[headerSize+i*sealed : headerSize+(i+1)*sealed] }
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	flip := func(at int) []byte {
+		damaged := append([]byte{}, encrypted...)
+		damaged[at] ^= 1
+		return damaged
+	}
+	header := encrypted[:headerSize]
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"cut at a chunk", encrypted[:headerSize+sealed]},
+		{"cut at two chunks", encrypted[:headerSize+2*sealed]},
+		{"cut inside a chunk", encrypted[:headerSize+sealed+100]},
+		{"cut last byte", encrypted[:len(encrypted)-1]},
+		{"header only", header},
+		{"dropped chunk", join(header, chunk(0), chunk(2), encrypted[headerSize+3*sealed:])},
+		{"reordered chunks", join(header, chunk(1), chunk(0), chunk(2), encrypted[headerSize+3*sealed:])},
+		{"repeated chunk", join(header, chunk(0), chunk(0), chunk(1), chunk(2), encrypted[headerSize+3*sealed:])},
+		{"flipped salt", flip(headerSize - 6)},
+		{"flipped chunk size", flip(headerSize - 1)},
+		{"flipped content", flip(headerSize + sealed + 10)},
+		{"flipped tag", flip(len(encrypted) - 1)},
+		{"trailing data", join(encrypted, []byte("x"))},
+	}
+	for _, test := range tests {
+		if got, err := decryptTest(test.data, testPassword); err == nil {
+			t.Errorf("%s: decrypted %d bytes", test.name, len(got))
+		}
+	}
+}
+
+func TestDecryptFullLastChunk(t *testing.T) {
+	encrypted := encryptTest(t, make([]byte, 2*chunkSize))
+	if _, err := decryptTest(append(encrypted, 'x'), testPassword); err != ErrDecrypt {
+		t.Fatalf("trailing data after a full last chunk gives %v", err)
+	}
+	// dropping the last chunk leaves a full one that is not marked last
+	if _, err := decryptTest(encrypted[:headerSize+chunkSize+16], testPassword); err == nil {
+		t.Fatal("cut off stream decrypted")
+	}
+}
+
+func TestEncryptDecryptFile(t *testing.T) {
+	defer func(k KDF) { KeyDerivation = k }(KeyDerivation)
+	KeyDerivation = PBKDF2(1000)
+	path := filepath.Join(t.TempDir(), "file")
+	plain := make([]byte, 200000)
+	rand.New(rand.NewSource(1)).Read(plain)
+	os.WriteFile(path, plain, 0640)
+	if err := Encrypt(path, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if encrypted, _ := os.ReadFile(path); bytes.Contains(encrypted, plain[:1000]) {
+		t.Fatal("plaintext left in the encrypted file")
+	}
+	if err := Decrypt(path, []byte("wrong")); err != ErrDecrypt {
+		t.Fatalf("wrong password gives %v", err)
+	}
+	if err := Decrypt(path, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	info, _ := os.Stat(path)
+	if !bytes.Equal(got, plain) || info.Mode().Perm() != 0640 {
+		t.Fatalf("got %d bytes with mode %v", len(got), info.Mode())
+	}
+}
+
+func TestKeyDerivations(t *testing.T) {
+	defer func(k KDF) { KeyDerivation = k }(KeyDerivation)
+	for _, kdf := range []KDF{Argon2id(1, 1024, 1), Argon2id(3, 64*1024, 4), Scrypt(15, 8, 1), PBKDF2(10000)} {
+		KeyDerivation = kdf
+		var buf bytes.Buffer
+		w, err := NewEncryptWriter(&buf, testPassword)
+		if err != nil {
+			t.Fatalf("%v: %v", kdf, err)
+		}
+		w.Write([]byte("hello"))
+		w.Close()
+		if h, _, err := readCryptHeader(bytes.NewReader(buf.Bytes())); err != nil || h.kdf != kdf {
+			t.Fatalf("%v: header holds %v, %v", kdf, h.kdf, err)
+		}
+		// the header, not KeyDerivation, tells how to derive the key
+		KeyDerivation = PBKDF2(1)
+		if got, err := decryptTest(buf.Bytes(), testPassword); err != nil || string(got) != "hello" {
+			t.Fatalf("%v: got %q, %v", kdf, got, err)
+		}
+	}
+}
+
+func TestDecryptHostileHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		kdf   KDF
+		chunk uint32
+	}{
+		{"PBKDF2 iterations", KDF{kdfPBKDF2, [3]uint32{1 << 31}}, chunkSize},
+		{"PBKDF2 without iterations", KDF{kdfPBKDF2, [3]uint32{}}, chunkSize},
+		{"scrypt cost", KDF{kdfScrypt, [3]uint32{30, 8, 1}}, chunkSize},
+		{"scrypt block size", KDF{kdfScrypt, [3]uint32{10, 1 << 20, 1}}, chunkSize},
+		{"scrypt parallelism", KDF{kdfScrypt, [3]uint32{10, 8, 1 << 20}}, chunkSize},
+		{"scrypt memory of 4 GiB", KDF{kdfScrypt, [3]uint32{20, 32, 1}}, chunkSize},
+		{"scrypt memory past 1 GiB", KDF{kdfScrypt, [3]uint32{20, 9, 1}}, chunkSize},
+		{"Argon2id passes", KDF{kdfArgon2id, [3]uint32{1000, 1024, 1}}, chunkSize},
+		{"Argon2id memory", KDF{kdfArgon2id, [3]uint32{1, 4 << 20, 1}}, chunkSize},
+		{"Argon2id threads", KDF{kdfArgon2id, [3]uint32{1, 1024, 0}}, chunkSize},
+		{"unknown", KDF{9, [3]uint32{1, 1, 1}}, chunkSize},
+		{"empty chunks", PBKDF2(1000), 0},
+		{"huge chunks", PBKDF2(1000), 1 << 31},
+	}
+	for _, test := range tests {
+		header := cryptHeader{kdf: test.kdf, salt: make([]byte, 16), chunk: test.chunk}.marshal()
+		start := time.Now()
+		if _, err := decryptTest(append(header, make([]byte, 100)...), testPassword); err == nil {
+			t.Errorf("%s: decrypted", test.name)
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Errorf("%s: took %v to refuse", test.name, time.Since(start))
+		}
+	}
+}
+
+// encryptLegacy encrypts plain the way files were before the chunked format.
+func encryptLegacy(plain, password []byte) []byte {
+	nonce := make([]byte, 12)
+	rand.New(rand.NewSource(1)).Read(nonce)
+	block, _ := aes.NewCipher(Key(password, nonce, 4096, 32, sha1.New))
+	gcm, _ := cipher.NewGCM(block)
+	return append(gcm.Seal(nil, nonce, plain, nil), nonce...)
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	defer func(size int64) { MaxLegacySize = size }(MaxLegacySize)
+	plain := []byte("content encrypted the old way")
+	legacy := encryptLegacy(plain, testPassword)
+	tests := []struct {
+		name     string
+		data     []byte
+		password []byte
+		limit    int64
+		err      error
+	}{
+		{"legacy", legacy, testPassword, 1 << 30, nil},
+		{"wrong password", legacy, []byte("wrong"), 1 << 30, ErrDecrypt},
+		{"too short", []byte("tiny"), testPassword, 1 << 30, errNotEncrypted},
+		{"too long", legacy, testPassword, int64(len(legacy) - 1), errLegacySize},
+		{"at the limit", legacy, testPassword, int64(len(legacy)), nil},
+	}
+	for _, test := range tests {
+		MaxLegacySize = test.limit
+		path := filepath.Join(t.TempDir(), "file")
+		os.WriteFile(path, test.data, 0600)
+		if err := Decrypt(path, test.password); err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+			continue
+		}
+		want := test.data
+		if test.err == nil {
+			want = plain
+		}
+		if got, _ := os.ReadFile(path); !bytes.Equal(got, want) {
+			t.Errorf("%s: file holds %q", test.name, got)
+		}
+	}
+}
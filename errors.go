@@ -0,0 +1,92 @@
+package dirk
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoSelection is returned by operations called on an empty selection.
+var ErrNoSelection = errors.New("No file selected")
+
+// ContinueOnError makes batch operations carry on with the rest of the
+// selection after a file fails, instead of stopping at the first failure.
+var ContinueOnError = false
+
+// errSkip is returned by a batch step to record its file as skipped.
+var errSkip = errors.New("skipped")
+
+// OpError records an operation that failed on a single path.
+type OpError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *OpError) Error() string { return e.Op + " " + e.Path + ": " + e.Err.Error() }
+
+// Unwrap returns the underlying cause.
+func (e *OpError) Unwrap() error { return e.Err }
+
+// Result lists what a batch operation did with each file of a selection.
+// Operations return it as their error when at least one file failed, so the
+// whole report is available through errors.As.
+type Result struct {
+	Op        string
+	Succeeded []string
+	Skipped   []string
+	Failed    []*OpError
+}
+
+func (r *Result) Error() string {
+	switch len(r.Failed) {
+	case 0:
+		return r.Op + ": no errors"
+	case 1:
+		return r.Failed[0].Error()
+	}
+	total := len(r.Succeeded) + len(r.Skipped) + len(r.Failed)
+	return fmt.Sprintf("%s: %d of %d files failed, first: %v", r.Op, len(r.Failed), total, r.Failed[0])
+}
+
+// Unwrap returns the errors of all failed files.
+func (r *Result) Unwrap() []error {
+	errs := make([]error, len(r.Failed))
+	for i := range r.Failed {
+		errs[i] = r.Failed[i]
+	}
+	return errs
+}
+
+// Err returns r when any file failed and nil otherwise.
+func (r *Result) Err() error {
+	if r == nil || len(r.Failed) == 0 {
+		return nil
+	}
+	return r
+}
+
+func (r *Result) fail(op, path string, err error) {
+	r.Failed = append(r.Failed, &OpError{Op: op, Path: path, Err: err})
+}
+
+// batch runs fn for every file and records the outcome in a Result. After a
+// failure the remaining files are recorded as skipped unless ContinueOnError
+// is set.
+func (files Files) batch(op string, fn func(f *File) error) *Result {
+	res := &Result{Op: op}
+	for i := range files {
+		switch err := fn(files[i]); err {
+		case nil:
+			res.Succeeded = append(res.Succeeded, files[i].Path)
+		case errSkip:
+			res.Skipped = append(res.Skipped, files[i].Path)
+		default:
+			res.fail(op, files[i].Path, err)
+			if !ContinueOnError {
+				res.Skipped = append(res.Skipped, files[i+1:].paths()...)
+				return res
+			}
+		}
+	}
+	return res
+}
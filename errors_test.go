@@ -0,0 +1,81 @@
+package dirk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchResult(t *testing.T) {
+	defer func(c bool) { ContinueOnError = c }(ContinueOnError)
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a", "b", "c", "d"} {
+		paths = append(paths, filepath.Join(dir, name))
+		os.WriteFile(paths[len(paths)-1], nil, 0644)
+	}
+	files, _ := MakeFiles(paths)
+	errBroken := errors.New("broken")
+	step := func(f *File) error {
+		switch f.Name {
+		case "b":
+			return errBroken
+		case "c":
+			return errSkip
+		}
+		return nil
+	}
+	tests := []struct {
+		continueOnError            bool
+		succeeded, skipped, failed int
+	}{
+		{false, 1, 2, 1},
+		{true, 2, 1, 1},
+	}
+	for _, test := range tests {
+		ContinueOnError = test.continueOnError
+		err := files.batch("test", step).Err()
+		var res *Result
+		if !errors.As(err, &res) {
+			t.Fatalf("continue %v: got %v", test.continueOnError, err)
+		}
+		if len(res.Succeeded) != test.succeeded || len(res.Skipped) != test.skipped || len(res.Failed) != test.failed {
+			t.Fatalf("continue %v: got %+v", test.continueOnError, res)
+		}
+		var opErr *OpError
+		if !errors.Is(err, errBroken) || !errors.As(err, &opErr) || opErr.Path != paths[1] || opErr.Op != "test" {
+			t.Fatalf("continue %v: %v does not hold the failure", test.continueOnError, err)
+		}
+	}
+	if err := files[:1].batch("test", step).Err(); err != nil {
+		t.Fatalf("successful batch gives %v", err)
+	}
+}
+
+func TestOperationErrors(t *testing.T) {
+	defer func(c bool) { ContinueOnError = c }(ContinueOnError)
+	ContinueOnError = true
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good")
+	os.WriteFile(good, []byte("content"), 0644)
+	missing := filepath.Join(dir, "missing")
+	files := Files{&File{Path: missing, Name: "missing"}, &File{Path: good, Name: "good"}}
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"touch", files.Touch()},
+		{"chmod", files.Chmod("u+x")},
+		{"read", func() error { _, err := files.Read2B(); return err }()},
+	}
+	for _, test := range tests {
+		var opErr *OpError
+		if !errors.As(test.err, &opErr) || opErr.Path != missing || !os.IsNotExist(errors.Unwrap(opErr)) {
+			t.Errorf("%s: got %v", test.name, test.err)
+		}
+	}
+	if err := (Files{}).Delete(); err != ErrNoSelection {
+		t.Errorf("empty selection gives %v", err)
+	}
+}
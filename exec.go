@@ -0,0 +1,279 @@
+package dirk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RunOptions control how Files.Exec runs a command template.
+type RunOptions struct {
+	Parallel int       // Parallel is how many commands run at once; zero means 1.
+	Stdin    io.Reader // Stdin can only be given when commands run one at a time.
+	// Stdout and Stderr also receive the output when set. Commands running
+	// in parallel write to them a whole line at a time.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Attach hands Stdin, Stdout and Stderr to the commands as they are, so
+	// programs such as editors and pagers keep the terminal. The output is
+	// then not captured in the results.
+	Attach bool
+}
+
+// RunResult is the outcome of one invocation of a command template.
+type RunResult struct {
+	Files    []string // Files the command was run on.
+	Args     []string
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int // ExitCode is -1 when the command did not start or was killed.
+	Err      error
+}
+
+var (
+	errEmptyCommand  = errors.New("empty command")
+	errParallelStdin = errors.New("commands running in parallel cannot share Stdin")
+)
+
+var placeholderRegex = regexp.MustCompile(`\{(|\+|dir|base|name|ext)\}`)
+
+// Exec runs a command template on the selection. The template is split like
+// a shell command line and may use these placeholders:
+//
+//	{}      the path of the file
+//	{+}     the paths of all files, in a single invocation
+//	{dir}   the directory of the file
+//	{base}  the file name
+//	{name}  the file name without its extension
+//	{ext}   the extension, without the dot
+//
+// Without {+} the command runs once per file; with it, fields holding the
+// other placeholders are repeated for every file. A template without any
+// placeholder gets the path appended. Results are returned in selection
+// order, and the error is a *Result naming the invocations that failed.
+func (files Files) Exec(template string, options ...RunOptions) ([]*RunResult, error) {
+	var opt RunOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+	return files.exec(splitCommand(template), opt)
+}
+
+// exec runs the fields of a command template on the selection.
+func (files Files) exec(fields []string, opt RunOptions) ([]*RunResult, error) {
+	if len(files) == 0 {
+		return nil, ErrNoSelection
+	}
+	if opt.Parallel < 1 {
+		opt.Parallel = 1
+	}
+	var out *sync.Mutex
+	if opt.Parallel > 1 {
+		if opt.Stdin != nil {
+			return nil, errParallelStdin
+		}
+		out = &sync.Mutex{}
+	}
+
+	invocations := expandTemplate(fields, files.paths())
+	results := make([]*RunResult, len(invocations))
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+		slots  = make(chan struct{}, opt.Parallel)
+	)
+	for i, inv := range invocations {
+		slots <- struct{}{}
+		mu.Lock()
+		stop := failed && !ContinueOnError
+		mu.Unlock()
+		if stop {
+			<-slots
+			break
+		}
+		wg.Add(1)
+		go func(i int, inv invocation) {
+			defer func() { <-slots; wg.Done() }()
+			r := inv.run(opt, out)
+			mu.Lock()
+			results[i] = r
+			failed = failed || r.Err != nil
+			mu.Unlock()
+		}(i, inv)
+	}
+	wg.Wait()
+
+	res := &Result{Op: "run"}
+	for i, r := range results {
+		switch {
+		case r == nil:
+			res.Skipped = append(res.Skipped, invocations[i].files...)
+		case r.Err != nil:
+			res.fail("run", strings.Join(r.Files, " "), r.Err)
+		default:
+			res.Succeeded = append(res.Succeeded, r.Files...)
+		}
+	}
+	done := results[:0]
+	for _, r := range results {
+		if r != nil {
+			done = append(done, r)
+		}
+	}
+	return done, res.Err()
+}
+
+type invocation struct {
+	files []string
+	args  []string
+}
+
+// run runs the invocation. Commands running in parallel share the writers
+// of opt under out, which is nil otherwise.
+func (inv invocation) run(opt RunOptions, out *sync.Mutex) *RunResult {
+	r := &RunResult{Files: inv.files, Args: inv.args, ExitCode: -1}
+	if len(inv.args) == 0 {
+		r.Err = errEmptyCommand
+		return r
+	}
+	var stdout, stderr bytes.Buffer
+	var shared []*lineWriter
+	output := func(capture *bytes.Buffer, w io.Writer) io.Writer {
+		if w != nil && out != nil {
+			lw := &lineWriter{w: w, mu: out}
+			shared = append(shared, lw)
+			w = lw
+		}
+		switch {
+		case opt.Attach:
+			// an *os.File is passed on as it is, so a terminal stays one
+			return w
+		case w == nil:
+			return capture
+		}
+		return io.MultiWriter(capture, w)
+	}
+	cmd := exec.Command(inv.args[0], inv.args[1:]...)
+	cmd.Stdin = opt.Stdin
+	cmd.Stdout, cmd.Stderr = output(&stdout, opt.Stdout), output(&stderr, opt.Stderr)
+	r.Err = cmd.Run()
+	for _, lw := range shared {
+		lw.flush()
+	}
+	if cmd.ProcessState != nil {
+		r.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	r.Stdout, r.Stderr = stdout.Bytes(), stderr.Bytes()
+	return r
+}
+
+// lineWriter lets commands running at once share a writer, passing it
+// whole lines only so that their output does not mix within a line.
+type lineWriter struct {
+	w    io.Writer
+	mu   *sync.Mutex
+	rest []byte // rest is the unfinished last line
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.rest = append(l.rest, p...)
+	i := bytes.LastIndexByte(l.rest, '\n')
+	if i < 0 {
+		return len(p), nil
+	}
+	l.mu.Lock()
+	_, err := l.w.Write(l.rest[:i+1])
+	l.mu.Unlock()
+	l.rest = append(l.rest[:0], l.rest[i+1:]...)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flush writes what is left of the last line.
+func (l *lineWriter) flush() {
+	if len(l.rest) == 0 {
+		return
+	}
+	l.mu.Lock()
+	l.w.Write(l.rest)
+	l.mu.Unlock()
+	l.rest = nil
+}
+
+// commandFields splits a command given to Run or Start. A command without
+// placeholders names a single program, spaces and all, that gets the path
+// of each file.
+func commandFields(command string) []string {
+	if !placeholderRegex.MatchString(command) {
+		return []string{command}
+	}
+	return splitCommand(command)
+}
+
+// expandTemplate turns the fields of a command template into the
+// invocations to run.
+func expandTemplate(fields []string, paths []string) []invocation {
+	all, found := false, false
+	for _, field := range fields {
+		for _, m := range placeholderRegex.FindAllStringSubmatch(field, -1) {
+			found = true
+			all = all || m[1] == "+"
+		}
+	}
+	if !found {
+		fields = append(fields, "{}")
+	}
+	if all {
+		args := []string{}
+		for _, field := range fields {
+			switch {
+			case field == "{+}":
+				args = append(args, paths...)
+			case strings.Contains(field, "{+}"):
+				args = append(args, strings.Replace(field, "{+}", strings.Join(paths, " "), -1))
+			case placeholderRegex.MatchString(field):
+				for _, path := range paths {
+					args = append(args, expandField(field, path))
+				}
+			default:
+				args = append(args, field)
+			}
+		}
+		return []invocation{{files: paths, args: args}}
+	}
+	invocations := make([]invocation, len(paths))
+	for i, path := range paths {
+		args := make([]string, len(fields))
+		for j, field := range fields {
+			args[j] = expandField(field, path)
+		}
+		invocations[i] = invocation{files: []string{path}, args: args}
+	}
+	return invocations
+}
+
+func expandField(field, path string) string {
+	return placeholderRegex.ReplaceAllStringFunc(field, func(token string) string {
+		base := filepath.Base(path)
+		ext := filepath.Ext(base)
+		switch token {
+		case "{dir}":
+			return filepath.Dir(path)
+		case "{base}":
+			return base
+		case "{name}":
+			return strings.TrimSuffix(base, ext)
+		case "{ext}":
+			return strings.TrimPrefix(ext, ".")
+		}
+		return path
+	})
+}
@@ -0,0 +1,208 @@
+package dirk
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	paths := []string{"/a/x.txt", "/b/y"}
+	tests := []struct {
+		template string
+		want     [][]string
+	}{
+		{"cat", [][]string{{"cat", "/a/x.txt"}, {"cat", "/b/y"}}},
+		{"cp {} {}.bak", [][]string{{"cp", "/a/x.txt", "/a/x.txt.bak"}, {"cp", "/b/y", "/b/y.bak"}}},
+		{"echo {dir} {base} {name} {ext}", [][]string{{"echo", "/a", "x.txt", "x", "txt"}, {"echo", "/b", "y", "y", ""}}},
+		{"tar -cf out.tar {+}", [][]string{{"tar", "-cf", "out.tar", "/a/x.txt", "/b/y"}}},
+		{"echo {+} -- x{base}", [][]string{{"echo", "/a/x.txt", "/b/y", "--", "xx.txt", "xy"}}},
+		{"sh -c 'echo {+}'", [][]string{{"sh", "-c", "echo /a/x.txt /b/y"}}},
+		{`printf '%s\n' {name}`, [][]string{{"printf", `%s\n`, "x"}, {"printf", `%s\n`, "y"}}},
+	}
+	for _, test := range tests {
+		var got [][]string
+		for _, inv := range expandTemplate(splitCommand(test.template), paths) {
+			got = append(got, inv.args)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.template, got, test.want)
+		}
+	}
+}
+
+func TestCommandFields(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"less", []string{"less"}},
+		{"/opt/My Tools/view", []string{"/opt/My Tools/view"}},
+		{"less -R {}", []string{"less", "-R", "{}"}},
+		{"'/opt/My Tools/view' {}", []string{"/opt/My Tools/view", "{}"}},
+	}
+	for _, test := range tests {
+		if got := commandFields(test.command); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.command, got, test.want)
+		}
+	}
+}
+
+func testFiles(t *testing.T, names ...string) Files {
+	dir := t.TempDir()
+	var files Files
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(name), 0644)
+		f, _ := MakeFile(path)
+		files = append(files, &f)
+	}
+	return files
+}
+
+func TestExec(t *testing.T) {
+	files := testFiles(t, "a.txt", "b.go", "c")
+	script := `sh -c 'echo {base}; echo {ext} >&2; test "{ext}" != go'`
+	tests := []struct {
+		name       string
+		continueOn bool
+		parallel   int
+		results    int
+		exitCodes  []int
+	}{
+		{"stop", false, 1, 2, []int{0, 1}},
+		{"continue", true, 1, 3, []int{0, 1, 0}},
+		{"parallel", true, 3, 3, []int{0, 1, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(continueOn bool) { ContinueOnError = continueOn }(ContinueOnError)
+			ContinueOnError = test.continueOn
+			results, err := files.Exec(script, RunOptions{Parallel: test.parallel})
+			var res *Result
+			if !errors.As(err, &res) || len(res.Failed) != 1 {
+				t.Fatalf("got error %v, want one failed invocation", err)
+			}
+			if len(results) != test.results {
+				t.Fatalf("got %d results, want %d", len(results), test.results)
+			}
+			for i, r := range results {
+				if r.ExitCode != test.exitCodes[i] {
+					t.Errorf("%s: exit code %d, want %d", r.Files[0], r.ExitCode, test.exitCodes[i])
+				}
+				if want := filepath.Base(r.Files[0]) + "\n"; string(r.Stdout) != want {
+					t.Errorf("%s: stdout %q, want %q", r.Files[0], r.Stdout, want)
+				}
+			}
+			if len(res.Succeeded)+len(res.Failed)+len(res.Skipped) != len(files) {
+				t.Fatalf("result covers %d of %d files", len(res.Succeeded)+len(res.Failed)+len(res.Skipped), len(files))
+			}
+		})
+	}
+}
+
+func TestExecMissingProgram(t *testing.T) {
+	files := testFiles(t, "a")
+	results, err := files.Exec("no-such-program-here")
+	if err == nil || len(results) != 1 || results[0].ExitCode != -1 || results[0].Err == nil {
+		t.Fatalf("got %v, %v", results, err)
+	}
+}
+
+func TestExecParallelOutput(t *testing.T) {
+	files := testFiles(t, "a", "b", "c", "d", "e", "f")
+	var stdout, stderr bytes.Buffer
+	_, err := files.Exec("sh -c 'cat {}; echo; cat {} >&2'", RunOptions{Parallel: 4, Stdout: &stdout, Stderr: &stderr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// commands running at once pass whole lines in turn
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	sort.Strings(lines)
+	if strings.Join(lines, " ") != "a b c d e f" || stderr.Len() != len(files) {
+		t.Fatalf("got stdout %q and stderr %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestExecParallelLines(t *testing.T) {
+	files := testFiles(t, "a", "b", "c", "d", "e", "f", "g", "h")
+	var stdout bytes.Buffer
+	// every command writes its lines in pieces, slowly
+	template := "sh -c 'for i in 1 2 3 4 5; do printf {base}; sleep 0.01; printf {base}; echo; done'"
+	if _, err := files.Exec(template, RunOptions{Parallel: 8, Stdout: &stdout}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	if len(lines) != 5*len(files) {
+		t.Fatalf("got %d lines", len(lines))
+	}
+	for _, line := range lines {
+		if len(line) != 2 || line[0] != line[1] {
+			t.Fatalf("mixed line %q", line)
+		}
+	}
+}
+
+func TestExecAttach(t *testing.T) {
+	files := testFiles(t, "a")
+	out, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	// an attached command gets the file itself, not a pipe
+	results, err := files.Exec("sh -c 'test -f /dev/stdout && cat {}'", RunOptions{Stdout: out, Attach: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Stdout != nil {
+		t.Fatalf("attached output captured as %q", results[0].Stdout)
+	}
+	if content, _ := os.ReadFile(out.Name()); string(content) != "a" {
+		t.Fatalf("file got %q", content)
+	}
+}
+
+func TestExecParallelStdin(t *testing.T) {
+	files := testFiles(t, "a", "b")
+	if _, err := files.Exec("cat", RunOptions{Parallel: 2, Stdin: strings.NewReader("x")}); err != errParallelStdin {
+		t.Fatalf("got %v, want %v", err, errParallelStdin)
+	}
+	results, err := files.Exec("cat -", RunOptions{Stdin: strings.NewReader("in")})
+	if err != nil || string(results[0].Stdout) != "ina" {
+		t.Fatalf("got %v, %v", results, err)
+	}
+}
+
+func TestRunProgramWithSpaces(t *testing.T) {
+	files := testFiles(t, "a", "b")
+	dir := filepath.Join(t.TempDir(), "my tools")
+	os.Mkdir(dir, 0755)
+	program := filepath.Join(dir, "mark")
+	os.WriteFile(program, []byte("#!/bin/sh\necho marked > \"$1.mark\"\n"), 0755)
+	if err := files.Run(program); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if _, err := os.Stat(f.Path + ".mark"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStartSkipsAfterFailure(t *testing.T) {
+	files := testFiles(t, "a", "b", "c")
+	err := files.Start("no-such-program-here")
+	var res *Result
+	if !errors.As(err, &res) {
+		t.Fatalf("got %v", err)
+	}
+	if len(res.Failed) != 1 || len(res.Skipped) != 2 || len(res.Succeeded) != 0 {
+		t.Fatalf("got %d failed, %d skipped, %d started", len(res.Failed), len(res.Skipped), len(res.Succeeded))
+	}
+}
@@ -2,7 +2,6 @@ package dirk
 
 import (
 	"archive/tar"
-	"archive/zip"
 	"bufio"
 	"compress/gzip"
 	"fmt"
@@ -11,10 +10,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
-
-	t "github.com/bresilla/shko/term"
+	"syscall"
+	"time"
 )
 
 var (
@@ -25,6 +25,9 @@ var (
 	DiskUse     = false
 	IgnoreSlice = []string{".git"}
 	IgnoreRecur = []string{"node_modules", ".git"}
+	// CompressionLevel is the level Archive compresses at, from 1 for the
+	// fastest to 9 for the smallest, zero being the default of the format
+	CompressionLevel = 0
 )
 
 func renameExist(name string) string {
@@ -42,7 +45,8 @@ func renameExist(name string) string {
 	return name
 }
 
-func cpFile(src, dst string) error {
+func cpFile(src, dst string, tr *tracker) error {
+	tr.file(src)
 	in, err := os.Open(src)
 	if err != nil {
 		return err
@@ -59,7 +63,7 @@ func cpFile(src, dst string) error {
 			err = e
 		}
 	}()
-	_, err = io.Copy(out, in)
+	err = copyData(out, in, tr)
 	if err != nil {
 		return err
 	}
@@ -71,10 +75,11 @@ func cpFile(src, dst string) error {
 	if err != nil {
 		return err
 	}
+	tr.fileDone()
 	return os.Chmod(dst, si.Mode())
 }
 
-func cpDir(src, dst string) error {
+func cpDir(src, dst string, tr *tracker) error {
 	src = filepath.Clean(src)
 	dst = filepath.Clean(dst)
 	si, err := os.Stat(src)
@@ -97,16 +102,23 @@ func cpDir(src, dst string) error {
 		srcPath := filepath.Join(src, entry.Name())
 		dstPath := filepath.Join(dst, entry.Name())
 		if entry.IsDir() {
-			err = cpDir(srcPath, dstPath)
+			err = cpDir(srcPath, dstPath, tr)
 			if err != nil {
 				return err
 			}
 		} else {
-			// Skip symlinks.
+			// Recreate symlinks rather than following them.
 			if entry.Mode()&os.ModeSymlink != 0 {
+				link, err := os.Readlink(srcPath)
+				if err != nil {
+					return err
+				}
+				if err = os.Symlink(link, dstPath); err != nil {
+					return err
+				}
 				continue
 			}
-			err = cpFile(srcPath, dstPath)
+			err = cpFile(srcPath, dstPath, tr)
 			if err != nil {
 				return err
 			}
@@ -115,7 +127,10 @@ func cpDir(src, dst string) error {
 	return err
 }
 
-func cpAny(src, dst string) error {
+func cpAny(src, dst string, tr *tracker) error {
+	if isVirtual(src) {
+		return cpVirtual(src, dst, tr)
+	}
 	srcinfo, err := os.Stat(src)
 	if err != nil {
 		return err
@@ -128,251 +143,337 @@ func cpAny(src, dst string) error {
 			}
 			dst += "/" + filepath.Base(src)
 			dst = renameExist(dst)
-			return cpDir(src, dst)
+			return cpDir(src, dst, tr)
 		}
-		return cpDir(src, dst)
+		return cpDir(src, dst, tr)
 	}
 	dstinfo, err := os.Stat(dst)
 	if err == nil {
 		if dstinfo.IsDir() {
-			return cpFile(src, dst+"/"+filepath.Base(src))
+			return cpFile(src, dst+"/"+filepath.Base(src), tr)
 		}
 		if os.SameFile(srcinfo, dstinfo) {
 			return nil
 		}
-		return cpFile(src, dst)
-	}
-	return cpFile(src, dst)
-}
-
-func unzip(archive, target string) error {
-	reader, err := zip.OpenReader(archive)
-	if err != nil {
-		return err
-	}
-	if err := os.MkdirAll(target, 0755); err != nil {
-		return err
-	}
-	for _, file := range reader.File {
-		path := filepath.Join(target, file.Name)
-		if file.FileInfo().IsDir() {
-			os.MkdirAll(path, file.Mode())
-			continue
-		}
-		fileReader, err := file.Open()
-		if err != nil {
-			return err
-		}
-		defer fileReader.Close()
-		targetFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			return err
-		}
-		defer targetFile.Close()
-		if _, err := io.Copy(targetFile, fileReader); err != nil {
-			return err
-		}
+		return cpFile(src, dst, tr)
 	}
-	return nil
+	return cpFile(src, dst, tr)
 }
 
-func zipit(source, target string) error {
-	zipfile, err := os.Create(target)
+func sameDevice(a, b string) bool {
+	ai, err := os.Lstat(a)
 	if err != nil {
-		return err
+		return false
 	}
-	defer zipfile.Close()
-
-	archive := zip.NewWriter(zipfile)
-	defer archive.Close()
-
-	info, err := os.Stat(source)
+	bi, err := os.Stat(b)
 	if err != nil {
-		return nil
-	}
-
-	var baseDir string
-	if info.IsDir() {
-		baseDir = filepath.Base(source)
+		return false
 	}
+	as, aok := ai.Sys().(*syscall.Stat_t)
+	bs, bok := bi.Sys().(*syscall.Stat_t)
+	return aok && bok && as.Dev == bs.Dev
+}
 
-	filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+func verifyCopy(src, dst string) error {
+	return filepath.Walk(src, func(path string, si os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-
-		header, err := zip.FileInfoHeader(info)
+		twin := filepath.Join(dst, strings.TrimPrefix(path, src))
+		di, err := os.Lstat(twin)
 		if err != nil {
 			return err
 		}
-
-		if baseDir != "" {
-			header.Name = filepath.Join(baseDir, strings.TrimPrefix(path, source))
+		if si.Mode()&os.ModeType != di.Mode()&os.ModeType {
+			return fmt.Errorf("type of %s differs from %s", twin, path)
 		}
-
-		if info.IsDir() {
-			header.Name += "/"
-		} else {
-			header.Method = zip.Deflate
+		if si.Mode().IsRegular() && si.Size() != di.Size() {
+			return fmt.Errorf("size of %s differs from %s", twin, path)
 		}
+		return nil
+	})
+}
 
-		writer, err := archive.CreateHeader(header)
-		if err != nil {
+func mvAny(src, dst string, tr *tracker) error {
+	src = filepath.Clean(src)
+	if info, err := os.Stat(dst); err == nil && info.IsDir() {
+		dst = filepath.Join(dst, filepath.Base(src))
+	}
+	dst = renameExist(filepath.Clean(dst))
+	if strings.HasPrefix(dst, src+"/") {
+		return fmt.Errorf("cannot move %s into itself", src)
+	}
+	if sameDevice(src, filepath.Dir(dst)) {
+		err := os.Rename(src, dst)
+		if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != syscall.EXDEV {
+			if err == nil {
+				tr.skip(dst)
+			}
 			return err
 		}
-
-		if info.IsDir() {
-			return nil
-		}
-
-		file, err := os.Open(path)
+	}
+	// across devices: copy, check the copy and only then drop the source
+	if info, err := os.Lstat(src); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(src)
 		if err != nil {
 			return err
 		}
-		defer file.Close()
-		_, err = io.Copy(writer, file)
+		if err := os.Symlink(link, dst); err != nil {
+			return err
+		}
+		return os.Remove(src)
+	}
+	if err := cpAny(src, dst, tr); err != nil {
+		os.RemoveAll(dst)
 		return err
-	})
-
-	return err
+	}
+	if err := verifyCopy(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
 }
 
-func tarit(source, target string) error {
-	filename := filepath.Base(source)
-	target = filepath.Join(target, fmt.Sprintf("%s.tar", filename))
-	tarfile, err := os.Create(target)
+// copyMember copies the content of the file at path into an archive.
+func copyMember(w io.Writer, path string, tr *tracker) error {
+	tr.file(path)
+	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
-	defer tarfile.Close()
-
-	tarball := tar.NewWriter(tarfile)
-	defer tarball.Close()
-
-	info, err := os.Stat(source)
-	if err != nil {
-		return nil
+	defer file.Close()
+	if _, err := io.Copy(w, tr.reader(file)); err != nil {
+		return err
 	}
+	tr.fileDone()
+	return nil
+}
 
-	var baseDir string
-	if info.IsDir() {
-		baseDir = filepath.Base(source)
+// sourceEntry describes the file at path as the member name of an archive.
+func sourceEntry(path, name string, info os.FileInfo) ArchiveEntry {
+	entry := ArchiveEntry{Name: name, CompressedSize: -1, Mode: info.Mode(), ModTime: info.ModTime()}
+	if info.Mode().IsRegular() {
+		entry.Size = info.Size()
 	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		entry.Link, _ = os.Readlink(path)
+	}
+	return entry
+}
 
-	return filepath.Walk(source,
-		func(path string, info os.FileInfo, err error) error {
+// walkSources calls fn for the sources and everything below them, with
+// slash separated names relative to their common parent, folders ending in
+// a slash. Members left out by opt.Filter are not passed to fn.
+func walkSources(sources []string, opt ArchiveOptions, fn func(path, name string, info os.FileInfo) error) error {
+	base := commonParent(sources)
+	for _, source := range sources {
+		source, err := filepath.Abs(source)
+		if err != nil {
+			return err
+		}
+		err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
-			header, err := tar.FileInfoHeader(info, info.Name())
+			name, err := filepath.Rel(base, path)
 			if err != nil {
 				return err
 			}
-
-			if baseDir != "" {
-				header.Name = filepath.Join(baseDir, strings.TrimPrefix(path, source))
-			}
-
-			if err := tarball.WriteHeader(header); err != nil {
-				return err
-			}
-
+			name = filepath.ToSlash(name)
 			if info.IsDir() {
-				return nil
+				name += "/"
 			}
-
-			file, err := os.Open(path)
-			if err != nil {
-				return err
+			if opt.Filter != nil || opt.Progress != nil {
+				entry := sourceEntry(path, name, info)
+				if opt.Filter != nil && !opt.Filter(entry) {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if opt.Progress != nil {
+					opt.Progress(entry)
+				}
 			}
-			defer file.Close()
-			_, err = io.Copy(tarball, file)
-			return err
+			return fn(path, name, info)
 		})
-}
-
-func untar(tarball, target string) error {
-	reader, err := os.Open(tarball)
-	if err != nil {
-		return err
+		if err != nil {
+			return err
+		}
 	}
-	defer reader.Close()
-	tarReader := tar.NewReader(reader)
+	return nil
+}
 
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return err
+// commonParent returns the deepest folder holding all paths.
+func commonParent(paths []string) string {
+	var parent []string
+	for i, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
 		}
+		dirs := strings.Split(filepath.Dir(abs), string(filepath.Separator))
+		if i == 0 {
+			parent = dirs
+			continue
+		}
+		n := 0
+		for n < len(parent) && n < len(dirs) && parent[n] == dirs[n] {
+			n++
+		}
+		parent = parent[:n]
+	}
+	if len(parent) <= 1 {
+		return string(filepath.Separator)
+	}
+	return strings.Join(parent, string(filepath.Separator))
+}
 
-		path := filepath.Join(target, header.Name)
-		info := header.FileInfo()
-		if info.IsDir() {
-			if err = os.MkdirAll(path, info.Mode()); err != nil {
+func tarit(sources []string, target io.Writer, opt ArchiveOptions, tr *tracker) error {
+	if opt.Reproducible {
+		sources = append([]string{}, sources...)
+		sort.Strings(sources)
+	}
+	tarball := tar.NewWriter(target)
+	type inode struct{ dev, ino uint64 }
+	linked := map[inode]string{} // first name of every file with hard links
+	err := walkSources(sources, opt, func(path, name string, info os.FileInfo) error {
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			var err error
+			if link, err = os.Readlink(path); err != nil {
 				return err
 			}
-			continue
 		}
-
-		file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+		header, err := tar.FileInfoHeader(info, link)
 		if err != nil {
 			return err
 		}
-		defer file.Close()
-		_, err = io.Copy(file, tarReader)
+		header.Name = name
+		header.Format = tar.FormatPAX
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && stat.Nlink > 1 {
+			key := inode{uint64(stat.Dev), uint64(stat.Ino)}
+			if first, ok := linked[key]; ok {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first
+				header.Size = 0
+			} else {
+				linked[key] = name
+			}
+		}
+		attrs, err := xattrs(path)
 		if err != nil {
 			return err
 		}
+		for attr, value := range attrs {
+			if header.PAXRecords == nil {
+				header.PAXRecords = map[string]string{}
+			}
+			header.PAXRecords["SCHILY.xattr."+attr] = value
+		}
+		if opt.Reproducible {
+			header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+			header.ModTime = opt.ModTime
+			if header.ModTime.IsZero() {
+				header.ModTime = time.Unix(0, 0)
+			}
+			header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+		}
+		if err := tarball.WriteHeader(header); err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		return copyMember(tarball, path, tr)
+	})
+	if err != nil {
+		return err
 	}
-	return err
+	return tarball.Close()
 }
 
-func ungzip(source, target string) error {
-	reader, err := os.Open(source)
+// compressit compresses a single file.
+func compressit(sources []string, target io.Writer, compression string, opt ArchiveOptions, tr *tracker) error {
+	if len(sources) != 1 {
+		return fmt.Errorf("%s holds a single file, not %d", compression, len(sources))
+	}
+	info, err := os.Stat(sources[0])
 	if err != nil {
 		return err
 	}
-	defer reader.Close()
-
-	archive, err := gzip.NewReader(reader)
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s holds a single file, %s is not one", compression, sources[0])
+	}
+	archiver, err := compressor(compression, target, opt.Level)
 	if err != nil {
 		return err
 	}
-	defer archive.Close()
-
-	target = filepath.Join(target, archive.Name)
-	writer, err := os.Create(target)
+	if header, ok := archiver.(*gzip.Writer); ok {
+		header.Name = filepath.Base(sources[0])
+		header.ModTime = info.ModTime()
+	}
+	err = walkSources(sources, opt, func(path, name string, info os.FileInfo) error {
+		return copyMember(archiver, path, tr)
+	})
 	if err != nil {
+		archiver.Close()
 		return err
 	}
-	defer writer.Close()
-
-	_, err = io.Copy(writer, archive)
-	return err
+	return archiver.Close()
 }
 
-func gzipit(source, target string) error {
-	reader, err := os.Open(source)
+// writeArchive packs sources into target as an archive of the given kind.
+func writeArchive(sources []string, target io.Writer, kind string, opt ArchiveOptions, tr *tracker) error {
+	tarred, compression := splitKind(kind)
+	switch {
+	case kind == "":
+		return fmt.Errorf("unknown archive kind %q", kind)
+	case kind == "zip":
+		return zipit(sources, target, opt, tr)
+	case kind == "tar":
+		return tarit(sources, target, opt, tr)
+	case !tarred:
+		return compressit(sources, target, compression, opt, tr)
+	}
+	archiver, err := compressor(compression, target, opt.Level)
 	if err != nil {
 		return err
 	}
+	if err := tarit(sources, archiver, opt, tr); err != nil {
+		archiver.Close()
+		return err
+	}
+	return archiver.Close()
+}
 
-	filename := filepath.Base(source)
-	target = filepath.Join(target, fmt.Sprintf("%s.gz", filename))
-	writer, err := os.Create(target)
+// archive packs sources into a single archive at target, named relative to
+// their common parent. A partly written archive is removed.
+func archive(sources []string, target string, tr *tracker) (err error) {
+	kind := archiveKind(target)
+	if kind == "" {
+		return fmt.Errorf("Not a proper archive name")
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
 	if err != nil {
 		return err
 	}
-	defer writer.Close()
-
-	archiver := gzip.NewWriter(writer)
-	archiver.Name = filename
-	defer archiver.Close()
-
-	_, err = io.Copy(archiver, reader)
-	return err
+	defer func() {
+		if e := out.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			os.Remove(target)
+		}
+	}()
+	buffered := bufio.NewWriter(out)
+	if err := writeArchive(sources, buffered, kind, ArchiveOptions{Level: CompressionLevel}, tr); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	return out.Sync()
 }
 
 func createDir(dirName string) bool {
@@ -416,6 +517,14 @@ func unique(intSlice []string) []string {
 	return list
 }
 
+func (files Files) paths() []string {
+	paths := make([]string, len(files))
+	for i := range files {
+		paths[i] = files[i].Path
+	}
+	return paths
+}
+
 func (dir File) ListDir() Files {
 	files := Files{}
 	list := chooseFile(IncFolder, IncFiles, IncHidden, Recurrent, dir)
@@ -446,9 +555,17 @@ func (dir File) Touch(names ...string) (Files, error) {
 	files := Files{}
 	for i := range names {
 		newFileName := dir.Path + "/" + names[i]
-		newFileName = renameExist(newFileName)
+		if _, err := os.Stat(newFileName); err == nil {
+			now := time.Now()
+			if err := os.Chtimes(newFileName, now, now); err != nil {
+				return files, &OpError{"touch", newFileName, err}
+			}
+			theFile, _ := MakeFile(newFileName)
+			files = append(files, &theFile)
+			continue
+		}
 		if newFile, err := os.Create(newFileName); err != nil {
-			return files, fmt.Errorf("Could not create file")
+			return files, &OpError{"touch", newFileName, err}
 		} else {
 			theFile, _ := MakeFile(newFileName)
 			files = append(files, &theFile)
@@ -464,7 +581,7 @@ func (dir File) Mkdir(names ...string) (Files, error) {
 		newFileName := dir.Path + "/" + names[i]
 		newFileName = renameExist(newFileName)
 		if err := os.MkdirAll(newFileName, 0777); err != nil {
-			return files, fmt.Errorf("Could not create folder")
+			return files, &OpError{"mkdir", newFileName, err}
 		} else {
 			theFile, _ := MakeFile(newFileName)
 			files = append(files, &theFile)
@@ -473,6 +590,71 @@ func (dir File) Mkdir(names ...string) (Files, error) {
 	return files, nil
 }
 
+// Touch sets the access and modification times of the selection: to now
+// without times, to times[0] for both with one, and to times[0] and
+// times[1] respectively with two.
+func (files Files) Touch(times ...time.Time) error {
+	if len(files) == 0 {
+		return ErrNoSelection
+	}
+	atime, mtime := time.Now(), time.Now()
+	switch len(times) {
+	case 0:
+	case 1:
+		atime, mtime = times[0], times[0]
+	default:
+		atime, mtime = times[0], times[1]
+	}
+	return files.batch("touch", func(f *File) error {
+		return os.Chtimes(f.Path, atime, mtime)
+	}).Err()
+}
+
+// TouchFrom gives the selection the access and modification times of ref.
+func (files Files) TouchFrom(ref File) error {
+	return files.Touch(ref.TimeAccess(), ref.File.ModTime())
+}
+
+// LinkOptions control the links Link makes.
+type LinkOptions struct {
+	// Relative points symbolic links at a path relative to the folder
+	// they are made in, instead of an absolute one.
+	Relative bool
+}
+
+// Link makes a link to every selected file inside the folder destin, under
+// the same name or a free variant of it. The links are symbolic or, when
+// symbolic is false, hard links.
+func (files Files) Link(destin File, symbolic bool, options ...LinkOptions) error {
+	if len(files) == 0 {
+		return ErrNoSelection
+	}
+	var opts LinkOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	return files.batch("link", func(f *File) error {
+		newFileName := renameExist(filepath.Join(destin.Path, f.Name))
+		if !symbolic {
+			return os.Link(f.Path, newFileName)
+		}
+		target, err := filepath.Abs(f.Path)
+		if err != nil {
+			return err
+		}
+		if opts.Relative {
+			dir, err := filepath.Abs(destin.Path)
+			if err != nil {
+				return err
+			}
+			if target, err = filepath.Rel(dir, target); err != nil {
+				return err
+			}
+		}
+		return os.Symlink(target, newFileName)
+	}).Err()
+}
+
 func (dir Files) Current() Files {
 	selected := Files{}
 	for i := range dir {
@@ -484,56 +666,38 @@ func (dir Files) Current() Files {
 }
 
 func (files Files) Paste(destin File) error {
-	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
-	}
-	for i := range files {
-		if _, err := os.Stat(files[i].Path); !os.IsNotExist(err) {
-			if err := cpAny(files[i].Path, destin.Path); err != nil {
-				return fmt.Errorf("Could not copy file!")
-			}
-		}
+	plan, err := files.PastePlan(destin)
+	if err != nil {
+		return err
 	}
-	return nil
+	return plan.Execute()
 }
 
 func (files Files) Move(destin File) error {
-	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
-	}
-	for i := range files {
-		if _, err := os.Stat(files[i].Path); !os.IsNotExist(err) {
-			if err := cpAny(files[i].Path, destin.Path); err != nil {
-				return fmt.Errorf("Could not copy file")
-			} else if err := os.RemoveAll(files[i].Path); err != nil {
-				return fmt.Errorf("Could not delete file")
-			}
-		}
+	plan, err := files.MovePlan(destin)
+	if err != nil {
+		return err
 	}
-	return nil
+	return plan.Execute()
 }
 
 func (files Files) Delete() error {
-	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
-	}
-	for i := range files {
-		if err := os.RemoveAll(files[i].Path); err != nil {
-			return fmt.Errorf("Could not delete file")
-		}
+	plan, err := files.DeletePlan()
+	if err != nil {
+		return err
 	}
-	return nil
+	return plan.Execute()
 }
 
 func (files Files) Read2B() ([][]byte, error) {
 	fileArray := [][]byte{}
 	if len(files) == 0 {
-		return fileArray, fmt.Errorf("No file selected")
+		return fileArray, ErrNoSelection
 	}
 	for i := range files {
 		jointMem, err := ioutil.ReadFile(files[i].Path)
 		if err != nil {
-			return fileArray, err
+			return fileArray, &OpError{"read", files[i].Path, err}
 		}
 		fileArray = append(fileArray, jointMem)
 	}
@@ -543,18 +707,12 @@ func (files Files) Read2B() ([][]byte, error) {
 func (files Files) Read2S() ([][]string, error) {
 	fileArray := [][]string{}
 	if len(files) == 0 {
-		return fileArray, fmt.Errorf("No file selected")
+		return fileArray, ErrNoSelection
 	}
 	for i := range files {
-		file, err := os.Open(files[i].Path)
+		lines, err := readLines(files[i].Path)
 		if err != nil {
-			return fileArray, err
-		}
-		defer file.Close()
-		var lines []string
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			lines = append(lines, scanner.Text())
+			return fileArray, &OpError{"read", files[i].Path, err}
 		}
 		fileArray = append(fileArray, lines)
 	}
@@ -563,57 +721,48 @@ func (files Files) Read2S() ([][]string, error) {
 
 func (files Files) Write(bytes []byte) error {
 	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
-	}
-	for i := range files {
-		newFileName := renameExist(files[i].Path)
-		if _, err := os.Create(newFileName); err != nil {
-			return fmt.Errorf("Could not create file")
-		} else if newFile, err := os.OpenFile(newFileName, os.O_RDWR|os.O_APPEND, 0777); err == nil {
-			if _, err := newFile.Write(bytes); err != nil {
-				return fmt.Errorf("Could not write file")
-			}
-			newFile.Close()
-		}
+		return ErrNoSelection
 	}
-	return nil
+	return files.batch("write", func(f *File) error {
+		return writeAtomic(f.Path, 0666, func(w io.Writer) error {
+			_, err := w.Write(bytes)
+			return err
+		})
+	}).Err()
 }
 
+// Append adds bytes to the end of the selected files. Existing files are
+// appended to in place and synced, so concurrent appenders keep their
+// writes; missing ones are created atomically like Write does.
 func (files Files) Append(bytes []byte) error {
 	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
+		return ErrNoSelection
 	}
-	for i := range files {
-		if _, err := os.Stat(files[i].Path); !os.IsNotExist(err) {
-			if newFile, err := os.OpenFile(files[i].Path, os.O_RDWR|os.O_APPEND, 0777); err == nil {
-				if _, err := newFile.Write(bytes); err != nil {
-					return fmt.Errorf("Could not write file")
-				}
-				newFile.Close()
-			}
-		} else {
-			if err := files.Write(bytes); err != nil {
+	return files.batch("append", func(f *File) error {
+		out, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND, 0)
+		if os.IsNotExist(err) {
+			return writeAtomic(f.Path, 0666, func(w io.Writer) error {
+				_, err := w.Write(bytes)
 				return err
-			}
+			})
+		} else if err != nil {
+			return err
 		}
-	}
-	return nil
+		if _, err = out.Write(bytes); err == nil {
+			err = out.Sync()
+		}
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}).Err()
 }
 
 func (files Files) Overite(bytes []byte) error {
-	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
-	}
-	if err := files.Delete(); err != nil {
-		return err
-	}
-	if err := files.Write(bytes); err != nil {
-		return err
-	}
-	return nil
+	return files.Write(bytes)
 }
 
-func (files Files) Union(name string) error {
+func (files Files) Union(name string, options ...UnionOptions) error {
 	isMixed := false
 	if len(files) < 1 {
 		return fmt.Errorf("Not enough files to join")
@@ -624,183 +773,150 @@ func (files Files) Union(name string) error {
 		}
 	}
 	virtDir, _ := MakeFile(files[0].Parent()[0].Path)
-	if !isMixed {
-		toWrite, _ := virtDir.Touch(name)
-		for i := range files {
-			toWrite.Append([]byte(strconv.Itoa(i)))
+	if isMixed {
+		toPlace, err := virtDir.Mkdir(name)
+		if err != nil {
+			return err
 		}
-		t.PrintWait("file")
-	} else {
-		toPlace, _ := virtDir.Mkdir(name)
-		files.Paste(*toPlace[0])
+		return files.Paste(*toPlace[0])
 	}
-	return nil
+	var opts UnionOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	target := renameExist(filepath.Join(virtDir.Path, name))
+	return writeAtomic(target, 0666, func(w io.Writer) error {
+		return files.concat(w, opts)
+	})
 }
 
 func (files Files) Indent(name string) error {
-	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
+	plan, err := files.IndentPlan(name)
+	if err != nil {
+		return err
 	}
-	virtDir, _ := MakeFile(files[0].Parent()[0].Path)
-	toPlace, _ := virtDir.Mkdir(name)
-	files.Paste(*toPlace[0])
-	files.Delete()
-	return nil
+	return plan.Execute()
 }
 
 func (files Files) Outdent(name ...string) error {
-	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
-	}
-	virtDir, _ := MakeFile(files[0].Parent()[0].Path)
-	virtDir, _ = MakeFile(virtDir.Parent()[0].Path)
-	if name[0] != "" {
-		toPlace, _ := virtDir.Mkdir(name[0])
-		files.Paste(*toPlace[0])
-		files.Delete()
-	} else {
-		files.Paste(virtDir)
-		files.Delete()
+	plan, err := files.OutdentPlan(name...)
+	if err != nil {
+		return err
 	}
-	return nil
+	return plan.Execute()
 }
 
 func (files Files) Rename(name ...string) error {
 	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
+		return ErrNoSelection
 	}
-	parent := files[0].Parent()[0].Path
-	if len(files) == len(name) {
+	if len(files) != len(name) {
+		if len(files) == 1 {
+			return nil
+		}
+		temp, err := os.CreateTemp(files[0].Parent()[0].Path, ".dirk-rename-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(temp.Name())
+		list := ""
 		for i := range files {
-			newFileName := renameExist(parent + "/" + name[i])
-			if err := os.Rename(files[i].Path, newFileName); err != nil {
-				return fmt.Errorf("Could not create folder")
-			}
+			list += files[i].Name + "\n"
 		}
-	} else {
-		if len(files) > 1 {
-			parentDir, _ := MakeFile(parent)
-			tempFile, _ := parentDir.Touch(".temp")
-			for i := range files {
-				tempFile.Append([]byte(files[i].Name + "\n"))
-			}
-			if err := tempFile.Edit(); err != nil {
-				return err
-			}
-			fmt.Print("\033[?25l")
-			newNames, _ := readLines(tempFile[0].Path)
-			if len(newNames) != len(files) {
-				tempFile.Delete()
-				return fmt.Errorf("Number of files and names don't match")
-			}
-			for i, name := range newNames {
-				newName := renameExist(name)
-				os.Rename(files[i].Path, files[i].Parent()[0].Path+newName)
-			}
-			tempFile.Delete()
+		_, err = temp.WriteString(list)
+		if cerr := temp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		tempFile, _ := MakeFiles([]string{temp.Name()})
+		if err := tempFile.Edit(); err != nil {
+			return err
+		}
+		fmt.Print("\033[?25l")
+		if name, err = readLines(temp.Name()); err != nil {
+			return err
 		}
 	}
-	return nil
+	plan, err := files.RenamePlan(name...)
+	if err != nil {
+		return err
+	}
+	return plan.Execute()
 }
 
 func (files Files) Archive(name string) error {
-	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
-	}
-	archSlice := []string{}
-	for i := range files {
-		archSlice = append(archSlice, files[i].Path)
-	}
-	for i := range files {
-		extension := filepath.Ext(name)
-		newFileName := renameExist(files[i].Parent()[0].Path + "/" + name)
-		switch extension {
-		case ".zip":
-			zipit(files[i].Path, newFileName)
-		case ".tar":
-			tarit(files[i].Path, newFileName)
-		case ".tgz", ".tar.gz":
-			gzipit(files[i].Path, newFileName)
-		default:
-			return fmt.Errorf("Not a proper archive name")
-		}
+	plan, err := files.ArchivePlan(name)
+	if err != nil {
+		return err
 	}
-	return nil
+	return plan.Execute()
 }
 
 func (files Files) Unarchive(name string) error {
-	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
-	}
-	for i := range files {
-		newFileName := renameExist(files[i].Parent()[0].Path + "/" + name)
-		switch files[i].MimeExte() {
-		case ".zip":
-			unzip(files[i].Path, newFileName)
-		case ".tar":
-			untar(files[i].Path, newFileName)
-		case ".tgz", ".tar.gz":
-			ungzip(files[i].Path, newFileName)
-		default:
-			return fmt.Errorf("Not an archive")
-		}
+	plan, err := files.UnarchivePlan(name)
+	if err != nil {
+		return err
 	}
-	return nil
+	return plan.Execute()
 }
 
-func (files Files) Run(name string) error {
-	var cmd *exec.Cmd
-	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
-	}
-	for i := range files {
-		cmd = exec.Command(name, files[i].Path)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		err := cmd.Run()
-		if err != nil {
-			return fmt.Errorf("Error:", err)
-		}
-	}
-	return nil
+// Run runs a command on the selection and waits for it, attached to the
+// terminal. A command without placeholders is a single program, which may
+// contain spaces, run once per file; with them it is a template (see Exec).
+func (files Files) Run(command string) error {
+	_, err := files.exec(commandFields(command), RunOptions{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr, Attach: true})
+	return err
 }
 
-func (files Files) Start(name string) error {
-	var cmd *exec.Cmd
+// Start starts a command on the selection, taken like Run takes it,
+// without waiting for it. The invocations left when it stops at an error
+// are reported as skipped.
+func (files Files) Start(command string) error {
 	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
+		return ErrNoSelection
 	}
-	for i := range files {
-		cmd = exec.Command(name, files[i].Path)
-		cmd.Stdin = os.Stdin
-		err := cmd.Start()
-		if err != nil {
-			return fmt.Errorf("Error:", err)
+	res := &Result{Op: "start"}
+	invocations := expandTemplate(commandFields(command), files.paths())
+	for i, inv := range invocations {
+		err := errEmptyCommand
+		if len(inv.args) > 0 {
+			cmd := exec.Command(inv.args[0], inv.args[1:]...)
+			cmd.Stdin = os.Stdin
+			err = cmd.Start()
+		}
+		if err == nil {
+			res.Succeeded = append(res.Succeeded, inv.files...)
+			continue
+		}
+		res.fail("start", strings.Join(inv.files, " "), err)
+		if !ContinueOnError {
+			for _, left := range invocations[i+1:] {
+				res.Skipped = append(res.Skipped, left.files...)
+			}
+			break
 		}
 	}
-	return nil
+	return res.Err()
 }
 
 func (files Files) Edit() error {
-	var cmd *exec.Cmd
 	if len(files) == 0 {
-		return fmt.Errorf("No file selected")
+		return ErrNoSelection
 	}
-	for i := range files {
+	return files.batch("edit", func(f *File) error {
+		var cmd *exec.Cmd
 		editor := os.Getenv("EDITOR")
 		if len(editor) > 0 {
-			cmd = exec.Command(editor, files[i].Path)
+			cmd = exec.Command(editor, f.Path)
 		} else {
-			cmd = exec.Command("/usr/bin/env", "nvim", files[i].Path)
+			cmd = exec.Command("/usr/bin/env", "nvim", f.Path)
 		}
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
-		err := cmd.Run()
-		if err != nil {
-			return fmt.Errorf("Error:", err)
-		}
-	}
-	return nil
+		return cmd.Run()
+	}).Err()
 }
 
 func (files Files) Match(finder Finder) Files {
@@ -817,9 +933,21 @@ func (files Files) Match(finder Finder) Files {
 
 func (files Files) Find(finder Finder) Files {
 	matched := Files{}
-	if len(finder.Text) == 0 && finder.Regex == nil {
+	if len(finder.Text) == 0 && finder.Regex == nil && len(finder.Bytes) == 0 {
 		return files
 	}
+	if len(finder.Bytes) > 0 {
+		for i := range files {
+			if !files[i].IsRegular() {
+				continue
+			}
+			files[i].offsets, _ = files[i].SearchBytes(finder.Bytes, 0)
+			if len(files[i].offsets) > 0 {
+				matched = append(matched, files[i])
+			}
+		}
+		return matched
+	}
 	for i := range files {
 		files[i].mapLine = make(map[int]string)
 		if files[i].MimeType()[1] != "text" {
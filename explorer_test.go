@@ -0,0 +1,334 @@
+package dirk
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		symbolic bool
+		options  []LinkOptions
+		target   func(dir string) string // target is the wanted link target, "" for a hard link
+	}{
+		{"hard", false, nil, func(string) string { return "" }},
+		{"absolute", true, nil, func(dir string) string { return filepath.Join(dir, "file") }},
+		{"relative", true, []LinkOptions{{Relative: true}}, func(string) string { return "../file" }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			os.Mkdir(filepath.Join(dir, "sub"), 0755)
+			os.WriteFile(filepath.Join(dir, "file"), []byte("content"), 0644)
+			files, _ := MakeFiles([]string{filepath.Join(dir, "file")})
+			sub, _ := MakeFile(filepath.Join(dir, "sub"))
+			for _, name := range []string{"file", "file(1)"} {
+				if err := files.Link(sub, test.symbolic, test.options...); err != nil {
+					t.Fatal(err)
+				}
+				link := filepath.Join(dir, "sub", name)
+				if content, err := os.ReadFile(link); err != nil || string(content) != "content" {
+					t.Fatalf("%s: got %q, %v", name, content, err)
+				}
+				target, err := os.Readlink(link)
+				if want := test.target(dir); want != target || (want == "") != (err != nil) {
+					t.Fatalf("%s: links to %q, want %q", name, target, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTouch(t *testing.T) {
+	then := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	later := then.Add(time.Hour)
+	tests := []struct {
+		name         string
+		touch        func(Files, File) error
+		atime, mtime time.Time // zero for now
+	}{
+		{"now", func(files Files, _ File) error { return files.Touch() }, time.Time{}, time.Time{}},
+		{"one time", func(files Files, _ File) error { return files.Touch(then) }, then, then},
+		{"two times", func(files Files, _ File) error { return files.Touch(then, later) }, then, later},
+		{"from", func(files Files, ref File) error { return files.TouchFrom(ref) }, then, later},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path, refPath := filepath.Join(dir, "file"), filepath.Join(dir, "ref")
+			os.WriteFile(path, nil, 0644)
+			os.WriteFile(refPath, nil, 0644)
+			old := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+			os.Chtimes(path, old, old)
+			os.Chtimes(refPath, then, later)
+			files, _ := MakeFiles([]string{path})
+			ref, _ := MakeFile(refPath)
+			if err := test.touch(files, ref); err != nil {
+				t.Fatal(err)
+			}
+			touched, _ := MakeFile(path)
+			atime, mtime := touched.TimeAccess(), touched.File.ModTime()
+			if test.mtime.IsZero() {
+				if time.Since(mtime) > time.Minute {
+					t.Fatalf("modified at %v, not now", mtime)
+				}
+				return
+			}
+			if !atime.Equal(test.atime) || !mtime.Equal(test.mtime) {
+				t.Fatalf("got %v and %v, want %v and %v", atime, mtime, test.atime, test.mtime)
+			}
+		})
+	}
+}
+
+func TestTouchExisting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	os.WriteFile(path, []byte("content"), 0644)
+	old := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	os.Chtimes(path, old, old)
+	folder, _ := MakeFile(dir)
+	if _, err := folder.Touch("file"); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	if info.ModTime().Equal(old) {
+		t.Fatal("existing file not touched")
+	}
+	if content, _ := os.ReadFile(path); string(content) != "content" {
+		t.Fatalf("existing file emptied to %q", content)
+	}
+	if _, err := os.Stat(path + "(1)"); err == nil {
+		t.Fatal("touch made a sibling")
+	}
+}
+
+func TestRenameWithEditor(t *testing.T) {
+	dir := t.TempDir()
+	editor := filepath.Join(dir, "editor")
+	os.WriteFile(editor, []byte("#!/bin/sh\nprintf 'x\\ny\\n' > \"$1\"\n"), 0755)
+	t.Setenv("EDITOR", editor)
+	work := filepath.Join(dir, "work")
+	os.Mkdir(work, 0755)
+	for _, name := range []string{"a", "b", ".temp"} {
+		os.WriteFile(filepath.Join(work, name), []byte(name), 0644)
+	}
+	files, _ := MakeFiles([]string{filepath.Join(work, "a"), filepath.Join(work, "b")})
+	if err := files.Rename(); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(work)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if len(names) != 3 || names[0] != ".temp" || names[1] != "x" || names[2] != "y" {
+		t.Fatalf("folder holds %v", names)
+	}
+	if content, _ := os.ReadFile(filepath.Join(work, ".temp")); string(content) != ".temp" {
+		t.Fatalf(".temp changed to %q", content)
+	}
+}
+
+func TestTarMetadata(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	long := filepath.Join(src, strings.Repeat("d", 80), strings.Repeat("e", 80))
+	os.MkdirAll(long, 0755)
+	os.WriteFile(filepath.Join(long, "f.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(src, "a"), []byte("aaa"), 0600)
+	os.Link(filepath.Join(src, "a"), filepath.Join(src, "b"))
+	os.Symlink("a", filepath.Join(src, "s"))
+	var buf bytes.Buffer
+	if err := tarit([]string{src}, &buf, ArchiveOptions{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]*tar.Header{}
+	r := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		headers[header.Name] = header
+	}
+	tests := []struct {
+		name     string
+		typeflag byte
+		link     string
+	}{
+		{"src/a", tar.TypeReg, ""},
+		{"src/b", tar.TypeLink, "src/a"},
+		{"src/s", tar.TypeSymlink, "a"},
+		{"src/" + strings.Repeat("d", 80) + "/" + strings.Repeat("e", 80) + "/f.txt", tar.TypeReg, ""},
+	}
+	for _, test := range tests {
+		header := headers[test.name]
+		if header == nil || header.Typeflag != test.typeflag || header.Linkname != test.link {
+			t.Errorf("%s: got %+v", test.name, header)
+		}
+	}
+	if header := headers["src/a"]; header != nil && header.Uname == "" {
+		t.Error("no owner name recorded")
+	}
+
+	archive := filepath.Join(dir, "archive.tar")
+	os.WriteFile(archive, buf.Bytes(), 0644)
+	out := filepath.Join(dir, "out")
+	if err := extract(archive, out); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := os.Stat(filepath.Join(out, "src", "a"))
+	b, _ := os.Stat(filepath.Join(out, "src", "b"))
+	if a == nil || b == nil || !os.SameFile(a, b) {
+		t.Error("hard link not restored")
+	}
+	if link, _ := os.Readlink(filepath.Join(out, "src", "s")); link != "a" {
+		t.Errorf("symbolic link to %q", link)
+	}
+	rel, _ := filepath.Rel(dir, long)
+	if content, _ := os.ReadFile(filepath.Join(out, rel, "f.txt")); string(content) != "hello" {
+		t.Error("long name not restored")
+	}
+}
+
+func TestTarReproducible(t *testing.T) {
+	when := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		modTime time.Time
+		want    time.Time
+	}{
+		{"epoch", time.Time{}, time.Unix(0, 0)},
+		{"fixed", when, when},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "src")
+			os.MkdirAll(filepath.Join(src, "d"), 0755)
+			os.WriteFile(filepath.Join(src, "b"), []byte("b"), 0644)
+			os.WriteFile(filepath.Join(src, "d", "a"), []byte("a"), 0644)
+			opt := ArchiveOptions{Reproducible: true, ModTime: test.modTime}
+			var one, two bytes.Buffer
+			if err := tarit([]string{src}, &one, opt, nil); err != nil {
+				t.Fatal(err)
+			}
+			os.Chtimes(filepath.Join(src, "b"), time.Now(), time.Now().Add(time.Hour))
+			if err := tarit([]string{src}, &two, opt, nil); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(one.Bytes(), two.Bytes()) {
+				t.Fatal("output changed with the times of a file")
+			}
+			r := tar.NewReader(&one)
+			for {
+				header, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if header.Uid != 0 || header.Gid != 0 || header.Uname != "" || !header.ModTime.Equal(test.want) {
+					t.Errorf("%s: owned by %d %q at %v", header.Name, header.Uid, header.Uname, header.ModTime)
+				}
+			}
+		})
+	}
+}
+
+// testMoveTree makes a folder with a file and a link to it below dir.
+func testMoveTree(dir string) string {
+	src := filepath.Join(dir, "tree")
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	os.WriteFile(filepath.Join(src, "sub", "f"), []byte("hello"), 0640)
+	os.Symlink("sub/f", filepath.Join(src, "ln"))
+	return src
+}
+
+// otherDevice returns a folder on another filesystem than the temporary
+// folders, or skips the test.
+func otherDevice(t *testing.T) string {
+	dir, err := os.MkdirTemp("/dev/shm", "dirk")
+	if err != nil || sameDevice(dir, t.TempDir()) {
+		os.RemoveAll(dir)
+		t.Skip("no second filesystem")
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestMove(t *testing.T) {
+	tests := []struct {
+		name string
+		dest func(t *testing.T) string
+	}{
+		{"same device", func(t *testing.T) string { return t.TempDir() }},
+		{"other device", otherDevice},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := testMoveTree(t.TempDir())
+			dest := test.dest(t)
+			files, _ := MakeFiles([]string{src})
+			destin, _ := MakeFile(dest)
+			if err := files.Move(destin); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Lstat(src); !os.IsNotExist(err) {
+				t.Fatal("source left behind")
+			}
+			moved := filepath.Join(dest, "tree")
+			if content, err := os.ReadFile(filepath.Join(moved, "ln")); err != nil || string(content) != "hello" {
+				t.Fatalf("got %q, %v", content, err)
+			}
+			if link, _ := os.Readlink(filepath.Join(moved, "ln")); link != "sub/f" {
+				t.Fatalf("link points to %q", link)
+			}
+			if info, _ := os.Stat(filepath.Join(moved, "sub", "f")); info.Mode().Perm() != 0640 {
+				t.Fatalf("moved with mode %v", info.Mode())
+			}
+		})
+	}
+}
+
+func TestMoveRollback(t *testing.T) {
+	dest := otherDevice(t)
+	src := testMoveTree(t.TempDir())
+	// a socket cannot be opened, so copying it fails half way
+	listener, err := net.Listen("unix", filepath.Join(src, "sub", "socket"))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer listener.Close()
+	files, _ := MakeFiles([]string{src})
+	destin, _ := MakeFile(dest)
+	if err := files.Move(destin); err == nil {
+		t.Fatal("moved a socket")
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "tree")); !os.IsNotExist(err) {
+		t.Fatal("partial copy left behind")
+	}
+	if content, _ := os.ReadFile(filepath.Join(src, "sub", "f")); string(content) != "hello" {
+		t.Fatal("source damaged")
+	}
+}
+
+func TestMoveIntoItself(t *testing.T) {
+	src := testMoveTree(t.TempDir())
+	if err := mvAny(src, filepath.Join(src, "sub"), nil); err == nil {
+		t.Fatal("moved into itself")
+	}
+	if _, err := os.Stat(filepath.Join(src, "sub", "f")); err != nil {
+		t.Fatal(err)
+	}
+}
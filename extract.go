@@ -0,0 +1,322 @@
+package dirk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	MaxExtractSize    int64 = 16 << 30 // MaxExtractSize caps the bytes written by one extraction.
+	MaxExtractEntries       = 1 << 20  // MaxExtractEntries caps the members of one extraction.
+)
+
+var (
+	errExtractSize    = errors.New("archive expands beyond MaxExtractSize")
+	errExtractEntries = errors.New("archive holds more than MaxExtractEntries members")
+)
+
+// extract unpacks an archive, recognised by its content, into the folder
+// target. Members stay inside target: leading slashes are dropped, names
+// climbing out of it are refused, nothing is written through a link and
+// links may not point outside it. Modes, without setuid, setgid and sticky
+// bits, times and the user extended attributes of files and folders are
+// restored.
+func extract(source, target string) error {
+	return unpack(target, ArchiveOptions{}, nil, func(fn func(ArchiveEntry, func() (io.ReadCloser, error)) error) error {
+		return walkArchive(source, fn)
+	})
+}
+
+// unpack extracts the members walk passes on into target, counting the
+// data written on tr. A target made here is removed again when extraction
+// fails.
+func unpack(target string, opt ArchiveOptions, tr *tracker, walk func(func(ArchiveEntry, func() (io.ReadCloser, error)) error) error) (err error) {
+	if _, statErr := os.Lstat(target); os.IsNotExist(statErr) {
+		defer func() {
+			if err != nil {
+				os.RemoveAll(target)
+			}
+		}()
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	// links are resolved against the real root, see resolve
+	root, err := filepath.Abs(target)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return err
+	}
+	x := &extraction{root: root, opt: opt, tr: tr, left: MaxExtractSize, symlinks: map[string]string{}, hardlinks: map[string]string{}}
+	if err := walk(x.member); err != nil {
+		return err
+	}
+	return x.finish()
+}
+
+// extraction is the state of one extract. Links are made once every file
+// is written, so that no member can be written through one.
+type extraction struct {
+	root      string
+	opt       ArchiveOptions
+	tr        *tracker
+	skipped   []string // skipped holds the folders left out by opt.Filter
+	left      int64
+	entries   int
+	dirs      []ArchiveEntry    // dirs are named by their path, restored last
+	symlinks  map[string]string // symlinks maps paths to link targets
+	hardlinks map[string]string // hardlinks maps paths to the paths linked
+}
+
+func (x *extraction) member(e ArchiveEntry, open func() (io.ReadCloser, error)) error {
+	name := cleanEntry(e.Name)
+	for _, folder := range x.skipped {
+		if strings.HasPrefix(name, folder) {
+			return nil
+		}
+	}
+	if x.opt.Filter != nil && !x.opt.Filter(e) {
+		if e.IsDir() {
+			x.skipped = append(x.skipped, name+"/")
+		}
+		return nil
+	}
+	if x.opt.Progress != nil {
+		x.opt.Progress(e)
+	}
+	x.entries++
+	if x.entries > MaxExtractEntries {
+		return errExtractEntries
+	}
+	target, err := x.path(e.Name)
+	if err != nil {
+		return err
+	}
+	e.Xattrs = restorable(e.Xattrs, x.opt.XattrNamespaces)
+	if err := x.mkdirs(filepath.Dir(target)); err != nil {
+		return err
+	}
+	// the last member of a name wins
+	delete(x.symlinks, target)
+	delete(x.hardlinks, target)
+	switch {
+	case e.IsDir():
+		if err := x.mkdirs(target); err != nil {
+			return err
+		}
+		e.Name = target
+		x.dirs = append(x.dirs, e)
+		return nil
+	case e.Mode&os.ModeSymlink != 0:
+		if filepath.IsAbs(e.Link) || !x.inside(filepath.Join(filepath.Dir(target), e.Link)) {
+			return fmt.Errorf("%s: link to %s leaves the archive", e.Name, e.Link)
+		}
+		x.symlinks[target] = e.Link
+		return nil
+	case e.Link != "":
+		linked, err := x.path(e.Link)
+		if err != nil {
+			return err
+		}
+		x.hardlinks[target] = linked
+		return nil
+	case !e.Mode.IsRegular():
+		// devices, pipes and sockets are not extracted
+		return nil
+	}
+	if err := x.clear(target); err != nil {
+		return err
+	}
+	content, err := open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	return writeMember(target, e, capped{content, &x.left}, x.tr)
+}
+
+func (x *extraction) finish() error {
+	// a link may only leave through another one, so where each leads is
+	// worked out before any is made
+	for target, linked := range x.hardlinks {
+		dir, err := x.resolve(filepath.Dir(linked))
+		if err != nil || !x.inside(dir) {
+			return fmt.Errorf("%s: hard link to a file outside the archive", target)
+		}
+	}
+	for target, link := range x.symlinks {
+		resolved, err := x.resolve(target)
+		if err != nil {
+			return fmt.Errorf("%s: %v", target, err)
+		}
+		if !x.inside(resolved) {
+			return fmt.Errorf("%s: link to %s leaves the archive", target, link)
+		}
+	}
+	for target, linked := range x.hardlinks {
+		info, err := os.Lstat(linked)
+		if err != nil || !info.Mode().IsRegular() {
+			return fmt.Errorf("%s: hard link to a missing file", target)
+		}
+		if err := x.clear(target); err != nil {
+			return err
+		}
+		if err := os.Link(linked, target); err != nil {
+			return err
+		}
+	}
+	for target, link := range x.symlinks {
+		if err := x.clear(target); err != nil {
+			return err
+		}
+		if err := os.Symlink(link, target); err != nil {
+			return err
+		}
+	}
+	// folder modes and times last, so that writing into them still works
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		setXattrs(x.dirs[i].Name, x.dirs[i].Xattrs)
+		os.Chmod(x.dirs[i].Name, x.dirs[i].Mode.Perm())
+		if !x.dirs[i].ModTime.IsZero() {
+			os.Chtimes(x.dirs[i].Name, x.dirs[i].ModTime, x.dirs[i].ModTime)
+		}
+	}
+	return nil
+}
+
+// resolve follows the links along path as the system will once every link
+// of the extraction is made: a link still to be made wins over what is on
+// disk, and the target of a hard link is a plain file.
+func (x *extraction) resolve(path string) (string, error) {
+	resolved, rest := string(filepath.Separator), strings.Split(path, string(filepath.Separator))
+	for hops := 0; len(rest) > 0; {
+		part := rest[0]
+		rest = rest[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		link, planned := x.symlinks[next]
+		if !planned {
+			info, err := os.Lstat(next)
+			if _, replaced := x.hardlinks[next]; replaced || err != nil || info.Mode()&os.ModeSymlink == 0 {
+				resolved = next
+				continue
+			}
+			if link, err = os.Readlink(next); err != nil {
+				return "", err
+			}
+		}
+		if hops++; hops > 255 {
+			return "", errors.New("too many levels of links")
+		}
+		if filepath.IsAbs(link) {
+			resolved = string(filepath.Separator)
+		}
+		rest = append(strings.Split(link, string(filepath.Separator)), rest...)
+	}
+	return resolved, nil
+}
+
+// path places a member inside the root, refusing names that leave it.
+func (x *extraction) path(name string) (string, error) {
+	name = filepath.Clean(filepath.FromSlash(strings.TrimLeft(name, "/")))
+	if name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: member leaves the archive", name)
+	}
+	return filepath.Join(x.root, name), nil
+}
+
+func (x *extraction) inside(path string) bool {
+	return within(x.root, filepath.Clean(path))
+}
+
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// mkdirs makes the folders down to dir one by one, refusing to pass
+// through anything that is not a folder, links included.
+func (x *extraction) mkdirs(dir string) error {
+	rel, err := filepath.Rel(x.root, dir)
+	if err != nil {
+		return err
+	}
+	current := x.root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(current, 0755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !info.IsDir():
+			return fmt.Errorf("%s: not a folder", current)
+		}
+	}
+	return nil
+}
+
+// clear removes an earlier member of the same name.
+func (x *extraction) clear(target string) error {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s: a folder is in the way", target)
+	}
+	return os.Remove(target)
+}
+
+// restorable keeps the attributes of the user namespace and of the other
+// namespaces given.
+func restorable(attrs map[string]string, namespaces []string) map[string]string {
+	kept := map[string]string{}
+	for name, value := range attrs {
+		namespace := name[:strings.IndexByte(name, '.')+1]
+		if namespace == "user." {
+			kept[name] = value
+		}
+		for _, allowed := range namespaces {
+			if namespace == allowed+"." {
+				kept[name] = value
+			}
+		}
+	}
+	return kept
+}
+
+// capped fails once more bytes than left have been read through it.
+type capped struct {
+	r    io.Reader
+	left *int64
+}
+
+func (c capped) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.left -= int64(n)
+	if *c.left < 0 {
+		return n, errExtractSize
+	}
+	return n, err
+}
@@ -0,0 +1,248 @@
+package dirk
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testMember describes a member of an archive built by a test.
+type testMember struct {
+	name, link string
+	typ        byte // typ is a tar type flag, also used for zips
+	body       string
+	mode       int64
+}
+
+var testTime = time.Unix(1000000000, 0)
+
+func writeTestTar(t *testing.T, path string, members []testMember) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := tar.NewWriter(f)
+	for _, m := range members {
+		header := &tar.Header{Name: m.name, Linkname: m.link, Typeflag: m.typ, Mode: m.mode, ModTime: testTime}
+		if header.Mode == 0 {
+			header.Mode = 0644
+		}
+		if m.typ == tar.TypeReg {
+			header.Size = int64(len(m.body))
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(m.body))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestZip(t *testing.T, path string, members []testMember) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, m := range members {
+		header := &zip.FileHeader{Name: m.name, Method: zip.Deflate, Modified: testTime}
+		body := m.body
+		switch m.typ {
+		case tar.TypeSymlink:
+			header.SetMode(os.ModeSymlink | 0777)
+			body = m.link
+		case tar.TypeDir:
+			header.SetMode(os.ModeDir | 0755)
+		default:
+			header.SetMode(0644)
+		}
+		member, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		member.Write([]byte(body))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractRefusesEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []testMember
+	}{
+		{"slip", []testMember{{name: "../../evil", typ: tar.TypeReg, body: "x"}}},
+		{"slip inside", []testMember{{name: "d/../../evil", typ: tar.TypeReg, body: "x"}}},
+		{"absolute link", []testMember{{name: "l", link: "/etc", typ: tar.TypeSymlink}}},
+		{"link up", []testMember{{name: "l", link: "../..", typ: tar.TypeSymlink}}},
+		{"write through link", []testMember{{name: "d/", typ: tar.TypeDir}, {name: "l", link: "d", typ: tar.TypeSymlink}, {name: "l/x", typ: tar.TypeReg, body: "x"}}},
+		{"link chain", []testMember{{name: "s/", typ: tar.TypeDir}, {name: "s/l1", link: "..", typ: tar.TypeSymlink}, {name: "s/l2", link: "l1/..", typ: tar.TypeSymlink}}},
+		{"hard link out", []testMember{{name: "h", link: "../../etc/passwd", typ: tar.TypeLink}}},
+		{"hard link missing", []testMember{{name: "h", link: "nowhere", typ: tar.TypeLink}}},
+	}
+	for _, test := range tests {
+		for _, kind := range []string{"tar", "zip"} {
+			if kind == "zip" && test.members[len(test.members)-1].typ == tar.TypeLink {
+				continue // zips hold no hard links
+			}
+			t.Run(test.name+" "+kind, func(t *testing.T) {
+				dir := filepath.Join(t.TempDir(), "a", "b")
+				os.MkdirAll(dir, 0755)
+				archive := filepath.Join(dir, "archive."+kind)
+				if kind == "tar" {
+					writeTestTar(t, archive, test.members)
+				} else {
+					writeTestZip(t, archive, test.members)
+				}
+				target := filepath.Join(dir, "out")
+				if err := extract(archive, target); err == nil {
+					t.Fatal("extracted")
+				}
+				if _, err := os.Lstat(target); !os.IsNotExist(err) {
+					t.Fatal("target left behind")
+				}
+				for _, escaped := range []string{filepath.Join(dir, "evil"), filepath.Join(dir, "..", "evil")} {
+					if _, err := os.Lstat(escaped); err == nil {
+						t.Fatalf("%s written", escaped)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestExtractIntoExistingFolder(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []testMember
+	}{
+		{"link chain", []testMember{{name: "d/", typ: tar.TypeDir}, {name: "d/s", link: "..", typ: tar.TypeSymlink}, {name: "b", link: "d/s/..", typ: tar.TypeSymlink}}},
+		{"link loop", []testMember{{name: "a", link: "b", typ: tar.TypeSymlink}, {name: "b", link: "a", typ: tar.TypeSymlink}}},
+		{"link through folder link", []testMember{{name: "l", link: "out/..", typ: tar.TypeSymlink}}},
+		{"hard link through folder link", []testMember{{name: "h", link: "out/secret", typ: tar.TypeLink}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			outside := filepath.Join(dir, "outside")
+			os.Mkdir(outside, 0755)
+			os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0600)
+			// the target is kept when extraction fails, links already in it too
+			target := filepath.Join(dir, "target")
+			os.Mkdir(target, 0755)
+			os.Symlink(outside, filepath.Join(target, "out"))
+			archive := filepath.Join(dir, "archive.tar")
+			writeTestTar(t, archive, test.members)
+			if err := extract(archive, target); err == nil {
+				t.Fatal("extracted")
+			}
+			entries, _ := os.ReadDir(target)
+			for _, e := range entries {
+				if e.Type()&os.ModeSymlink != 0 && e.Name() != "out" || e.Name() == "h" {
+					t.Errorf("%s left behind", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestExtract(t *testing.T) {
+	members := []testMember{
+		{name: "/abs/a.txt", typ: tar.TypeReg, body: "abs", mode: 0640},
+		{name: "d/", typ: tar.TypeDir, mode: 0700},
+		{name: "d/f", typ: tar.TypeReg, body: "old"},
+		{name: "d/f", typ: tar.TypeReg, body: "new", mode: 04755},
+		{name: "d/h", link: "d/f", typ: tar.TypeLink},
+		{name: "d/s", link: "f", typ: tar.TypeSymlink},
+		{name: "d/up", link: "../abs/a.txt", typ: tar.TypeSymlink},
+		{name: "fifo", typ: tar.TypeFifo},
+	}
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive.tar")
+	writeTestTar(t, archive, members)
+	target := filepath.Join(dir, "out")
+	if err := extract(archive, target); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path    string
+		content string
+		mode    os.FileMode
+	}{
+		{"abs/a.txt", "abs", 0640},
+		{"d/f", "new", 0755}, // the last member wins, without setuid
+		{"d/h", "new", 0755},
+		{"d/s", "new", 0755},
+		{"d/up", "abs", 0640},
+	}
+	for _, test := range tests {
+		path := filepath.Join(target, filepath.FromSlash(test.path))
+		content, err := os.ReadFile(path)
+		if err != nil || string(content) != test.content {
+			t.Errorf("%s: got %q, %v, want %q", test.path, content, err, test.content)
+			continue
+		}
+		info, _ := os.Stat(path)
+		if info.Mode() != test.mode || !info.ModTime().Equal(testTime) {
+			t.Errorf("%s: got %v at %v, want %v at %v", test.path, info.Mode(), info.ModTime(), test.mode, testTime)
+		}
+	}
+	if info, _ := os.Stat(filepath.Join(target, "d")); info.Mode().Perm() != 0700 || !info.ModTime().Equal(testTime) {
+		t.Errorf("d: got %v at %v", info.Mode(), info.ModTime())
+	}
+	if _, err := os.Lstat(filepath.Join(target, "fifo")); err == nil {
+		t.Error("fifo extracted")
+	}
+}
+
+func TestExtractZipLinks(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive.zip")
+	writeTestZip(t, archive, []testMember{
+		{name: "d/", typ: tar.TypeDir},
+		{name: "d/f", typ: tar.TypeReg, body: "content"},
+		{name: "s", link: "d/f", typ: tar.TypeSymlink},
+	})
+	target := filepath.Join(dir, "out")
+	if err := extract(archive, target); err != nil {
+		t.Fatal(err)
+	}
+	if link, err := os.Readlink(filepath.Join(target, "s")); err != nil || link != "d/f" {
+		t.Fatalf("got %q, %v", link, err)
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "big.zip")
+	writeTestZip(t, archive, []testMember{
+		{name: "a", body: string(make([]byte, 1000))},
+		{name: "b", body: string(make([]byte, 1000))},
+		{name: "c", body: string(make([]byte, 1000))},
+	})
+	defer func(size int64, entries int) { MaxExtractSize, MaxExtractEntries = size, entries }(MaxExtractSize, MaxExtractEntries)
+	tests := []struct {
+		name    string
+		size    int64
+		entries int
+		err     error
+	}{
+		{"size", 2500, 1 << 20, errExtractSize},
+		{"entries", 16 << 30, 2, errExtractEntries},
+		{"within", 3000, 3, nil},
+	}
+	for _, test := range tests {
+		MaxExtractSize, MaxExtractEntries = test.size, test.entries
+		if err := extract(archive, filepath.Join(dir, test.name)); err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+}
@@ -47,12 +47,13 @@ type File struct {
 
 	numLines int
 	mapLine  map[int]string
+	offsets  []int64
 	maxSize  int64
 	maxPath  int
 }
 
 func MakeFile(dir string) (file File, err error) {
-	f, err := os.Stat(dir)
+	f, err := statAny(dir)
 	if err != nil {
 		return
 	}
@@ -87,6 +88,7 @@ func (f File) TimeAccess() time.Time  { return timespecToTime(f.Stat.Atim) }
 func (f File) TimeChange() time.Time  { return timespecToTime(f.Stat.Ctim) }
 func (f File) MaxPath() int           { return f.maxPath }
 func (f File) MaxSize() int64         { return f.maxSize }
+func (f File) Offsets() []int64       { return f.offsets }
 func (f File) Parent() Files          { return Filer([]string{getParentPath(f)}) }
 func (f File) Siblings() Files        { return Filer(elements(getParentPath(f))) }
 func (f File) Ancestors() Files       { return Filer(ancestor(getParentPath(f))) }
@@ -135,6 +137,18 @@ func fileList(recurrent bool, dir *File) (paths Files, err error) {
 	var wg sync.WaitGroup
 	tempfiles := Element{}
 	var file File
+	if root, ok := virtualRoot(dir.Path); ok {
+		children, err := virtualChildren(root, recurrent)
+		if err != nil {
+			return paths, err
+		}
+		for _, child := range children {
+			if file, err := MakeFile(child); err == nil {
+				paths = append(paths, &file)
+			}
+		}
+		return paths, nil
+	}
 	if recurrent {
 		err = Walk(dir.Path, &Options{
 			Callback: func(osPathname string, de *Dirent) (err error) {
@@ -254,6 +268,9 @@ func byteCountIEC(b int64) string {
 }
 
 func getSize(file File, dumode bool) (size int64) {
+	if size, _, ok := measureVirtual(file.Path); ok && dumode {
+		return size
+	}
 	if dumode {
 		Walk(file.Path, &Options{
 			Callback: func(osPathname string, de *Dirent) (err error) {
@@ -275,6 +292,10 @@ func getSize(file File, dumode bool) (size int64) {
 
 func elements(dir string) (childs []string) {
 	childs = []string{}
+	if root, ok := virtualRoot(dir); ok {
+		childs, _ = virtualChildren(root, false)
+		return
+	}
 	if someChildren, err := ReadDirnames(dir, nil); err == nil {
 		for i := range someChildren {
 			childs = append(childs, dir+someChildren[i])
@@ -323,6 +344,9 @@ func getParent(f File) string {
 }
 
 func getParentPath(f File) string {
+	if parentPath, ok := virtualParent(f.Path); ok {
+		return parentPath
+	}
 	_, parentPath := parentInfo(f.Path)
 	return parentPath
 }
@@ -357,6 +381,10 @@ func getExte(f File) string {
 		return "."
 	} else {
 		extension := path.Ext(f.Path)
+		// compressed tars keep both extensions, as in .tar.gz
+		if path.Ext(strings.TrimSuffix(f.Path, extension)) == ".tar" {
+			return ".tar" + extension
+		}
 		return extension
 	}
 }
@@ -9,7 +9,6 @@ package dirk
 
 import (
 	"bufio"
-	"os"
 	"regexp"
 	"sort"
 	"strings"
@@ -240,11 +239,12 @@ func max(x int, y int) int {
 type Finder struct {
 	Text  string
 	Regex *regexp.Regexp
+	Bytes []byte // Bytes matches any file holding them, see HexPattern.
 }
 
 func readAndFind(file *File, finder Finder) {
 	numLine := 0
-	toread, err := os.Open(file.Path)
+	toread, err := openAny(file.Path)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,176 @@
+package dirk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// HexPageSize is the number of bytes HexDump dumps when no Length is given.
+var HexPageSize int64 = 4096
+
+// HexOptions control File.HexDump and File.WriteHexDump.
+type HexOptions struct {
+	Offset int64 // Offset is the first byte dumped.
+	// Length limits the bytes dumped; zero means a page of HexPageSize
+	// bytes for HexDump and the rest of the file for WriteHexDump.
+	Length int64
+	Width  int // Width is the number of bytes per line; zero means 16.
+	Group  int // Group is the number of bytes between spaces; zero means 1.
+}
+
+// HexDump returns a hex and ASCII dump of the file in the style of
+// hexdump -C, one line per Width bytes, starting at Offset. Use Offset and
+// Length to page through large files.
+func (f File) HexDump(options ...HexOptions) (string, error) {
+	var opt HexOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+	if opt.Length <= 0 {
+		opt.Length = HexPageSize
+	}
+	var dump strings.Builder
+	err := f.WriteHexDump(&dump, opt)
+	return dump.String(), err
+}
+
+// WriteHexDump writes the dump HexDump makes to w as it reads the file, so
+// a whole file can be dumped without holding the dump in memory.
+func (f File) WriteHexDump(w io.Writer, options ...HexOptions) error {
+	var opt HexOptions
+	if len(options) > 0 {
+		opt = options[0]
+	}
+	file, err := openAny(f.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if seeker, ok := file.(io.Seeker); ok {
+		_, err = seeker.Seek(opt.Offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, file, opt.Offset)
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var reader io.Reader = file
+	if opt.Length > 0 {
+		reader = io.LimitReader(file, opt.Length)
+	}
+	width := opt.Width
+	if width < 1 {
+		width = 16
+	}
+
+	out := bufio.NewWriter(w)
+	buf := make([]byte, width*256)
+	offset := opt.Offset
+	for {
+		n, err := io.ReadFull(reader, buf)
+		for _, line := range hexLines(buf[:n], offset, width, opt.Group) {
+			out.WriteString(line)
+			out.WriteByte('\n')
+		}
+		offset += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return out.Flush()
+		} else if err != nil {
+			out.Flush()
+			return err
+		}
+	}
+}
+
+// hexLines formats data as hex dump lines of width bytes, grouped by group
+// bytes, with offsets counted from offset.
+func hexLines(data []byte, offset int64, width, group int) []string {
+	if width < 1 {
+		width = 16
+	}
+	if group < 1 {
+		group = 1
+	}
+	// every line is padded to the width of a full one
+	columns := width*2 + (width+group-1)/group
+	lines := []string{}
+	for i := 0; i < len(data); i += width {
+		row := data[i:]
+		if len(row) > width {
+			row = row[:width]
+		}
+		var hexed, text strings.Builder
+		for j, b := range row {
+			fmt.Fprintf(&hexed, "%02x", b)
+			if (j+1)%group == 0 || j == len(row)-1 {
+				hexed.WriteByte(' ')
+			}
+			if b >= 0x20 && b < 0x7f {
+				text.WriteByte(b)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%08x  %-*s |%s|", offset+int64(i), columns, hexed.String(), text.String()))
+	}
+	return lines
+}
+
+// HexPattern decodes a byte pattern written in hex, such as "de ad be ef"
+// or "0xdeadbeef", for SearchBytes and Finder.Bytes.
+func HexPattern(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	s = strings.Join(strings.Fields(s), "")
+	return hex.DecodeString(s)
+}
+
+// SearchBytes returns the offsets at which pattern occurs in the file,
+// reading it in chunks. A limit greater than zero stops the search after as
+// many matches.
+func (f File) SearchBytes(pattern []byte, limit int) ([]int64, error) {
+	offsets := []int64{}
+	if len(pattern) == 0 {
+		return offsets, nil
+	}
+	file, err := openAny(f.Path)
+	if err != nil {
+		return offsets, err
+	}
+	defer file.Close()
+
+	chunk := 64 * 1024
+	if chunk < len(pattern)*2 {
+		chunk = len(pattern) * 2
+	}
+	buf := make([]byte, chunk)
+	kept, base := 0, int64(0) // bytes carried over and the offset of buf[0]
+	for {
+		n, err := io.ReadFull(file, buf[kept:])
+		window := buf[:kept+n]
+		for start := 0; ; {
+			i := bytes.Index(window[start:], pattern)
+			if i < 0 {
+				break
+			}
+			offsets = append(offsets, base+int64(start+i))
+			if limit > 0 && len(offsets) >= limit {
+				return offsets, nil
+			}
+			start += i + 1
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return offsets, nil
+		} else if err != nil {
+			return offsets, err
+		}
+		// keep the tail, a match may straddle two reads
+		kept = len(pattern) - 1
+		copy(buf, window[len(window)-kept:])
+		base += int64(len(window) - kept)
+	}
+}
@@ -0,0 +1,105 @@
+package dirk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHexDump(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	os.WriteFile(path, data, 0644)
+	file, _ := MakeFile(path)
+	tests := []struct {
+		name  string
+		opt   HexOptions
+		lines int
+		first string
+	}{
+		{"page", HexOptions{}, 256, "00000000  00 01 02 03 04 05 06 07 08 09 0a 0b 0c 0d 0e 0f  |................|"},
+		{"paged", HexOptions{Offset: 0x41, Length: 20}, 2, "00000041  41 42 43 44 45 46 47 48 49 4a 4b 4c 4d 4e 4f 50  |ABCDEFGHIJKLMNOP|"},
+		{"narrow", HexOptions{Offset: 0x30, Length: 4, Width: 4}, 1, "00000030  30 31 32 33  |0123|"},
+		{"grouped", HexOptions{Offset: 0x61, Length: 8, Width: 8, Group: 4}, 1, "00000061  61626364 65666768  |abcdefgh|"},
+		{"last line", HexOptions{Offset: 9990}, 1, "00002706  06 07 08 09 0a 0b 0c 0d 0e 0f                    |..........|"},
+		{"past the end", HexOptions{Offset: 20000}, 0, ""},
+	}
+	for _, test := range tests {
+		dump, err := file.HexDump(test.opt)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		lines := strings.Split(strings.TrimSuffix(dump, "\n"), "\n")
+		if dump == "" {
+			lines = nil
+		}
+		if len(lines) != test.lines || (len(lines) > 0 && lines[0] != test.first) {
+			t.Errorf("%s: got %d lines, want %d starting %q", test.name, len(lines), test.lines, test.first)
+		}
+	}
+	var whole bytes.Buffer
+	if err := file.WriteHexDump(&whole); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(whole.String(), "\n"); lines != 625 {
+		t.Fatalf("whole dump of %d lines", lines)
+	}
+}
+
+func TestHexPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []byte
+		ok      bool
+	}{
+		{"deadbeef", []byte{0xde, 0xad, 0xbe, 0xef}, true},
+		{"0xDEAD", []byte{0xde, 0xad}, true},
+		{"de ad  be ef", []byte{0xde, 0xad, 0xbe, 0xef}, true},
+		{"abc", nil, false},
+		{"zz", nil, false},
+	}
+	for _, test := range tests {
+		got, err := HexPattern(test.pattern)
+		if (err == nil) != test.ok || (test.ok && !bytes.Equal(got, test.want)) {
+			t.Errorf("%q: got %x, %v", test.pattern, got, err)
+		}
+	}
+}
+
+func TestSearchBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	data := make([]byte, 200000)
+	// matches at the start, across the first chunk boundary and at the end
+	for _, at := range []int{0, 64*1024 - 2, 150000, len(data) - 4} {
+		copy(data[at:], "\xde\xad\xbe\xef")
+	}
+	os.WriteFile(path, data, 0644)
+	file, _ := MakeFile(path)
+	tests := []struct {
+		pattern []byte
+		limit   int
+		want    []int64
+	}{
+		{[]byte{0xde, 0xad, 0xbe, 0xef}, 0, []int64{0, 64*1024 - 2, 150000, int64(len(data) - 4)}},
+		{[]byte{0xde, 0xad, 0xbe, 0xef}, 2, []int64{0, 64*1024 - 2}},
+		{[]byte("missing"), 0, []int64{}},
+		{nil, 0, []int64{}},
+		{[]byte{0, 0}, 3, []int64{4, 5, 6}},
+	}
+	for _, test := range tests {
+		got, err := file.SearchBytes(test.pattern, test.limit)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%x limited to %d: got %v, %v", test.pattern, test.limit, got, err)
+		}
+	}
+	files, _ := MakeFiles([]string{path})
+	if found := files.Find(Finder{Bytes: []byte("\xbe\xef")}); len(found) != 1 || len(found[0].Offsets()) != 4 {
+		t.Errorf("Find got %d files", len(found))
+	}
+}
@@ -0,0 +1,191 @@
+package dirk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// UnionOptions control how Union concatenates files.
+type UnionOptions struct {
+	// Header is written before each file, with {name} and {path} replaced
+	// by the name and path of that file.
+	Header string
+	// Separator is written between two files.
+	Separator string
+	// Newlines turns CRLF and lone CR line endings into LF and makes sure
+	// every file ends with a newline. Leave it off for binary files.
+	Newlines bool
+}
+
+// SplitOptions control how Split cuts files into parts.
+type SplitOptions struct {
+	Size  int64 // Size is the number of bytes per part.
+	Lines int   // Lines is the number of lines per part, used when Size is 0.
+}
+
+// concat streams the content of every file to w in order.
+func (files Files) concat(w io.Writer, opts UnionOptions) error {
+	for i, f := range files {
+		if i > 0 && opts.Separator != "" {
+			if _, err := io.WriteString(w, opts.Separator); err != nil {
+				return err
+			}
+		}
+		if opts.Header != "" {
+			header := strings.NewReplacer("{name}", f.Name, "{path}", f.Path).Replace(opts.Header)
+			if _, err := io.WriteString(w, header); err != nil {
+				return err
+			}
+		}
+		in, err := os.Open(f.Path)
+		if err != nil {
+			return &OpError{"union", f.Path, err}
+		}
+		var r io.Reader = in
+		var lf *lfReader
+		if opts.Newlines {
+			lf = &lfReader{r: in, last: '\n'}
+			r = lf
+		}
+		_, err = io.Copy(w, r)
+		in.Close()
+		if err != nil {
+			return &OpError{"union", f.Path, err}
+		}
+		if lf != nil && lf.last != '\n' {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lfReader converts CRLF and CR line endings to LF on the fly.
+type lfReader struct {
+	r    io.Reader
+	cr   bool // the previous chunk ended with a CR
+	last byte // last byte returned
+}
+
+func (l *lfReader) Read(p []byte) (int, error) {
+	for {
+		n, err := l.r.Read(p)
+		out := p[:0]
+		for _, b := range p[:n] {
+			if l.cr {
+				l.cr = false
+				if b == '\n' {
+					continue
+				}
+			}
+			if b == '\r' {
+				l.cr = true
+				b = '\n'
+			}
+			out = append(out, b)
+		}
+		if len(out) > 0 {
+			l.last = out[len(out)-1]
+		}
+		if len(out) > 0 || err != nil || n == 0 {
+			return len(out), err
+		}
+	}
+}
+
+// Split cuts every selected file into numbered parts next to it (name.001,
+// name.002, ...) of options.Size bytes or options.Lines lines each. Selecting
+// the parts in order and calling Union puts the file back together. A file
+// whose parts already exist is not split again.
+func (files Files) Split(options SplitOptions) error {
+	if len(files) == 0 {
+		return ErrNoSelection
+	}
+	if options.Size <= 0 && options.Lines <= 0 {
+		return fmt.Errorf("Nothing to split by")
+	}
+	return files.batch("split", func(f *File) error {
+		if f.IsDir() {
+			return fmt.Errorf("is a directory")
+		}
+		return splitFile(f.Path, options)
+	}).Err()
+}
+
+// splitFile writes the parts of one file. When a part cannot be written,
+// the parts written before it are removed again.
+func splitFile(path string, opts SplitOptions) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var parts []string
+	defer func() {
+		if err != nil {
+			for _, name := range parts {
+				os.Remove(name)
+			}
+		}
+	}()
+	r := bufio.NewReader(in)
+	for part := 1; ; part++ {
+		if _, err := r.Peek(1); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%s.%03d", path, part)
+		err := writePart(name, func(w io.Writer) error {
+			if opts.Size > 0 {
+				_, err := io.CopyN(w, r, opts.Size)
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			for i := 0; i < opts.Lines; {
+				chunk, err := r.ReadSlice('\n')
+				if _, werr := w.Write(chunk); werr != nil {
+					return werr
+				}
+				switch err {
+				case nil:
+					i++
+				case bufio.ErrBufferFull:
+				case io.EOF:
+					return nil
+				default:
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		parts = append(parts, name)
+	}
+}
+
+// writePart creates a part that must not exist yet, so that a split never
+// overwrites earlier parts or other files. A part left half written by an
+// error is removed again.
+func writePart(name string, fill func(w io.Writer) error) error {
+	out, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	err = fill(out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name)
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package dirk
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	data := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(data)
+	tests := []struct {
+		name    string
+		content []byte
+		options SplitOptions
+		parts   int
+	}{
+		{"size", data, SplitOptions{Size: 30000}, 4},
+		{"exact size", data, SplitOptions{Size: 50000}, 2},
+		{"lines", []byte("1\n2\n3\n4\n5"), SplitOptions{Lines: 2}, 3},
+		{"long lines", bytes.Repeat([]byte("x"), 10000), SplitOptions{Lines: 1}, 1},
+		{"empty", nil, SplitOptions{Size: 10}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "file")
+			if err := os.WriteFile(path, test.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			files, _ := MakeFiles([]string{path})
+			if err := files.Split(test.options); err != nil {
+				t.Fatal(err)
+			}
+			parts, _ := filepath.Glob(path + ".*")
+			if len(parts) != test.parts {
+				t.Fatalf("got %d parts, want %d", len(parts), test.parts)
+			}
+			if test.parts == 0 {
+				return
+			}
+			selection, _ := MakeFiles(parts)
+			if err := selection.Union("joined"); err != nil {
+				t.Fatal(err)
+			}
+			joined, _ := os.ReadFile(filepath.Join(dir, "joined"))
+			if !bytes.Equal(joined, test.content) {
+				t.Fatal("joined parts differ from the file")
+			}
+		})
+	}
+}
+
+func TestSplitKeepsExistingParts(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	os.WriteFile(path, bytes.Repeat([]byte("x"), 100), 0644)
+	os.WriteFile(path+".002", []byte("keep"), 0644)
+	files, _ := MakeFiles([]string{path})
+	if err := files.Split(SplitOptions{Size: 40}); err == nil {
+		t.Fatal("split over an existing part")
+	}
+	if kept, _ := os.ReadFile(path + ".002"); string(kept) != "keep" {
+		t.Fatalf("existing part overwritten with %q", kept)
+	}
+	if _, err := os.Stat(path + ".001"); !os.IsNotExist(err) {
+		t.Fatal("parts of a failed split left behind")
+	}
+}
+
+func TestUnionNewlines(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	os.WriteFile(a, []byte("1\r\n2\r3"), 0644)
+	os.WriteFile(b, []byte("4\n"), 0644)
+	files, _ := MakeFiles([]string{a, b})
+	if err := files.Union("joined", UnionOptions{Header: "== {name}\n", Separator: "--\n", Newlines: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := "== a\n1\n2\n3\n--\n== b\n4\n"
+	if joined, _ := os.ReadFile(filepath.Join(dir, "joined")); string(joined) != want {
+		t.Fatalf("got %q, want %q", joined, want)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
 
 	"github.com/gabriel-vasile/mimetype/matchers/json"
 )
@@ -34,7 +33,7 @@ func DetectReader(r io.Reader) (mime, extension string, err error) {
 
 // DetectFile returns the mime type and extension of the provided file.
 func DetectFile(file string) (mime, extension string, err error) {
-	f, err := os.Open(file)
+	f, err := openAny(file)
 	if err != nil {
 		return Root.Mime(), Root.Extension(), err
 	}
@@ -114,7 +113,7 @@ func (n *Node) Tree() string {
 }
 
 var Root = NewNode("application/octet-stream", "", match_True,
-	SevenZ, Zip, Pdf, Ps, Psd, Ogg,
+	SevenZ, Zip, Tar, Bzip2, Xz, Zstd, Pdf, Ps, Psd, Ogg,
 	Png, Jpg, Gif, Webp, Tiff, Bmp, Ico,
 	Mp3, Flac, Midi, Ape, MusePack, Amr, Wav, Aiff, Au,
 	Mpeg, QuickTime, Mp4, WebM, ThreeGP, Avi, Flv, Mkv,
@@ -124,6 +123,10 @@ var Root = NewNode("application/octet-stream", "", match_True,
 // The list of nodes appended to the Root node
 var (
 	Gzip   = NewNode("application/gzip", "gz", match_Gzip)
+	Bzip2  = NewNode("application/x-bzip2", "bz2", match_Bzip2)
+	Xz     = NewNode("application/x-xz", "xz", match_Xz)
+	Zstd   = NewNode("application/zstd", "zst", match_Zstd)
+	Tar    = NewNode("application/x-tar", "tar", match_Tar)
 	SevenZ = NewNode("application/x-7z-compressed", "7z", match_SevenZ)
 	Zip    = NewNode("application/zip", "zip", match_Zip, Xlsx, Docx, Pptx, Epub, Jar)
 	Pdf    = NewNode("application/pdf", "pdf", match_Pdf)
@@ -290,6 +293,10 @@ var fileicons = map[string]string{
 	".suo":      "",
 	".t":        "",
 	".tar":      "",
+	".tar.bz2":  "",
+	".tar.gz":   "",
+	".tar.xz":   "",
+	".tar.zst":  "",
 	".tgz":      "",
 	".ts":       "",
 	".twig":     "",
@@ -301,6 +308,7 @@ var fileicons = map[string]string{
 	".xz":       "",
 	".yml":      "",
 	".zip":      "",
+	".zst":      "",
 }
 
 var categoryicons = map[string]string{
@@ -318,7 +326,7 @@ func match_Zip(in []byte) bool {
 
 // SevenZ matches a 7z archive.
 func match_SevenZ(in []byte) bool {
-	return bytes.Equal(in[:6], []byte{0x37, 0x7A, 0xBC, 0xAF, 0x27, 0x1C})
+	return bytes.HasPrefix(in, []byte{0x37, 0x7A, 0xBC, 0xAF, 0x27, 0x1C})
 }
 
 // Epub matches an EPUB file.
@@ -338,7 +346,27 @@ func match_Jar(in []byte) bool {
 
 // Gzip matched gzip files based on http://www.zlib.org/rfc-gzip.html#header-trailer
 func match_Gzip(in []byte) bool {
-	return bytes.Equal(in[:2], []byte{0x1f, 0x8b})
+	return bytes.HasPrefix(in, []byte{0x1f, 0x8b})
+}
+
+// Bzip2 matches a bzip2 compressed file.
+func match_Bzip2(in []byte) bool {
+	return bytes.HasPrefix(in, []byte("BZh"))
+}
+
+// Xz matches an xz compressed file.
+func match_Xz(in []byte) bool {
+	return bytes.HasPrefix(in, []byte{0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00})
+}
+
+// Zstd matches a Zstandard compressed file.
+func match_Zstd(in []byte) bool {
+	return bytes.HasPrefix(in, []byte{0x28, 0xB5, 0x2F, 0xFD})
+}
+
+// Tar matches a POSIX or GNU tar archive.
+func match_Tar(in []byte) bool {
+	return len(in) >= 262 && bytes.Equal(in[257:262], []byte("ustar"))
 }
 
 func match_Mp3(in []byte) bool {
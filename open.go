@@ -0,0 +1,368 @@
+package dirk
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Handler is a program files can be opened with.
+type Handler struct {
+	Name string // Name is the desktop entry id or the program.
+	// Exec is the command line. The desktop entry field codes %f, %F, %u
+	// and %U mark where the files go; without them files are appended.
+	Exec     string
+	Terminal bool // Terminal programs run attached to the terminal.
+}
+
+// Associations map MIME types and file extensions to handlers, most
+// preferred first. Whatever is loaded later takes precedence.
+type Associations struct {
+	handlers map[string][]Handler // by mime type, type/* or .ext
+}
+
+// NewAssociations returns an empty registry.
+func NewAssociations() *Associations {
+	return &Associations{handlers: map[string][]Handler{}}
+}
+
+// DefaultAssociations is the registry used by Files.Open and File.OpenWith.
+var DefaultAssociations = NewAssociations()
+
+// Add registers h for a MIME type ("text/plain"), a family ("image/*") or
+// an extension (".pdf"), ahead of the handlers already known for it.
+func (a *Associations) Add(pattern string, h ...Handler) {
+	pattern = strings.ToLower(pattern)
+	merged := []Handler{}
+	seen := map[string]bool{}
+	for _, h := range append(h, a.handlers[pattern]...) {
+		if !seen[h.Name] {
+			seen[h.Name] = true
+			merged = append(merged, h)
+		}
+	}
+	a.handlers[pattern] = merged
+}
+
+// Load reads associations from a dirk config file:
+//
+//	[mime]
+//	text/plain nvim;code
+//	image/* sxiv
+//	[ext]
+//	.pdf zathura
+//	[terminal]
+//	nvim true
+//
+// Programs listed as true under [terminal] run attached to the terminal.
+func (a *Associations) Load(file string) error {
+	conf := NewConfig()
+	if err := conf.Parse(file); err != nil {
+		return err
+	}
+	terminal := map[string]bool{}
+	if section := conf.Get("terminal"); section != nil {
+		for _, key := range section.Keys() {
+			terminal[key], _ = section.Bool(key)
+		}
+	}
+	for _, name := range []string{"mime", "ext"} {
+		section := conf.Get(name)
+		if section == nil {
+			continue
+		}
+		for _, key := range section.Keys() {
+			commands, _ := section.Strings(key, ";")
+			handlers := []Handler{}
+			for _, command := range commands {
+				if command = strings.TrimSpace(command); command != "" {
+					program := strings.Fields(command)[0]
+					handlers = append(handlers, Handler{Name: program, Exec: command, Terminal: terminal[program]})
+				}
+			}
+			a.Add(key, handlers...)
+		}
+	}
+	return nil
+}
+
+// LoadXDG reads the desktop entries and mimeapps.list files of the XDG base
+// directories, honouring default, added and removed associations.
+func (a *Associations) LoadXDG() error {
+	home, _ := os.UserHomeDir()
+	dataDirs := []string{xdgDir("XDG_DATA_HOME", filepath.Join(home, ".local/share"))}
+	dataDirs = append(dataDirs, filepath.SplitList(xdgDir("XDG_DATA_DIRS", "/usr/local/share:/usr/share"))...)
+	configDirs := []string{xdgDir("XDG_CONFIG_HOME", filepath.Join(home, ".config"))}
+	configDirs = append(configDirs, filepath.SplitList(xdgDir("XDG_CONFIG_DIRS", "/etc/xdg"))...)
+
+	// desktop entries, the first directory defining an id wins
+	entries := map[string]Handler{}
+	declared := map[string][]string{}
+	for _, dir := range dataDirs {
+		dir = filepath.Join(dir, "applications")
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !strings.HasSuffix(path, ".desktop") {
+				return nil
+			}
+			id := strings.Replace(strings.TrimPrefix(path, dir+"/"), "/", "-", -1)
+			if _, ok := entries[id]; ok {
+				return nil
+			}
+			h, mimes, ok := readDesktop(path, id)
+			if ok {
+				entries[id] = h
+				for _, mime := range mimes {
+					if mime = strings.TrimSpace(mime); mime != "" {
+						declared[mime] = append(declared[mime], id)
+					}
+				}
+			}
+			return nil
+		})
+	}
+
+	// mimeapps.list files, most important first
+	defaults, added := map[string][]string{}, map[string][]string{}
+	removed := map[string]map[string]bool{}
+	var lists []string
+	for _, dir := range configDirs {
+		lists = append(lists, filepath.Join(dir, "mimeapps.list"))
+	}
+	for _, dir := range dataDirs {
+		lists = append(lists, filepath.Join(dir, "applications", "mimeapps.list"))
+	}
+	for _, list := range lists {
+		conf := NewConfig()
+		conf.Spliter = "="
+		if conf.Parse(list) != nil {
+			continue
+		}
+		for _, group := range []struct {
+			name string
+			into map[string][]string
+		}{{"Removed Associations", nil}, {"Default Applications", defaults}, {"Added Associations", added}} {
+			into := group.into
+			section := conf.Get(group.name)
+			if section == nil {
+				continue
+			}
+			for _, mime := range section.Keys() {
+				ids, _ := section.Strings(mime, ";")
+				for _, id := range ids {
+					if id = strings.TrimSpace(id); id == "" {
+						continue
+					}
+					if into == nil {
+						if removed[mime] == nil {
+							removed[mime] = map[string]bool{}
+						}
+						removed[mime][id] = true
+					} else if !removed[mime][id] {
+						into[mime] = append(into[mime], id)
+					}
+				}
+			}
+		}
+	}
+
+	mimes := map[string]bool{}
+	for _, m := range []map[string][]string{defaults, added, declared} {
+		for mime := range m {
+			mimes[mime] = true
+		}
+	}
+	for mime := range mimes {
+		var handlers []Handler
+		for _, ids := range [][]string{defaults[mime], added[mime], declared[mime]} {
+			for _, id := range ids {
+				if h, ok := entries[id]; ok && !removed[mime][id] {
+					handlers = append(handlers, h)
+				}
+			}
+		}
+		if len(handlers) > 0 {
+			a.Add(mime, handlers...)
+		}
+	}
+	return nil
+}
+
+func xdgDir(env, fallback string) string {
+	if dir := os.Getenv(env); dir != "" {
+		return dir
+	}
+	return fallback
+}
+
+// readDesktop parses the [Desktop Entry] group of a desktop file.
+func readDesktop(path, id string) (h Handler, mimes []string, ok bool) {
+	conf := NewConfig()
+	conf.Spliter = "="
+	if conf.Parse(path) != nil {
+		return
+	}
+	entry := conf.Get("Desktop Entry")
+	if entry == nil {
+		return
+	}
+	if hidden, _ := entry.Bool("Hidden"); hidden {
+		return
+	}
+	command, err := entry.String("Exec")
+	if err != nil || command == "" {
+		return
+	}
+	h = Handler{Name: id, Exec: command}
+	h.Terminal, _ = entry.Bool("Terminal")
+	mimes, _ = entry.Strings("MimeType", ";")
+	return h, mimes, true
+}
+
+// Handlers returns the handlers for f: those of its exact MIME type, then of
+// its type family, then of its extension.
+func (a *Associations) Handlers(f File) []Handler {
+	mime := strings.ToLower(strings.Join(f.MimeType(), "/"))
+	if i := strings.Index(mime, ";"); i >= 0 {
+		mime = strings.TrimSpace(mime[:i])
+	}
+	keys := []string{mime}
+	if mime == "folder/folder" {
+		keys = append(keys, "inode/directory")
+	}
+	if i := strings.Index(mime, "/"); i >= 0 {
+		keys = append(keys, mime[:i]+"/*")
+	}
+	if ext := f.MimeExte(); ext != "" && ext != "." {
+		keys = append(keys, strings.ToLower(ext))
+		if short := filepath.Ext(ext); short != ext {
+			keys = append(keys, strings.ToLower(short))
+		}
+	}
+	handlers := []Handler{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		for _, h := range a.handlers[key] {
+			if !seen[h.Name] {
+				seen[h.Name] = true
+				handlers = append(handlers, h)
+			}
+		}
+	}
+	return handlers
+}
+
+// OpenWith lists the programs that can open the file, the default first.
+func (f File) OpenWith() []Handler {
+	return DefaultAssociations.Handlers(f)
+}
+
+// Open opens every selected file with its default handler.
+func (files Files) Open() error {
+	if len(files) == 0 {
+		return ErrNoSelection
+	}
+	return files.batch("open", func(f *File) error {
+		handlers := f.OpenWith()
+		if len(handlers) == 0 {
+			return fmt.Errorf("no handler for %s", strings.Join(f.MimeType(), "/"))
+		}
+		return handlers[0].Open(Files{f})
+	}).Err()
+}
+
+// Open runs the handler on files, waiting for it when it is a terminal
+// program and leaving it running otherwise.
+func (h Handler) Open(files Files) error {
+	args := h.command(files.paths())
+	if len(args) == 0 {
+		return fmt.Errorf("empty command for %s", h.Name)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	if h.Terminal {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	return cmd.Start()
+}
+
+// command expands the desktop entry field codes of Exec.
+func (h Handler) command(paths []string) []string {
+	args := []string{}
+	used := false
+	for _, field := range splitCommand(h.Exec) {
+		switch field {
+		case "%f", "%u":
+			args = append(args, paths[0])
+			used = true
+			continue
+		case "%F", "%U":
+			args = append(args, paths...)
+			used = true
+			continue
+		}
+		var arg strings.Builder
+		for i := 0; i < len(field); i++ {
+			if field[i] != '%' || i+1 == len(field) {
+				arg.WriteByte(field[i])
+				continue
+			}
+			i++
+			switch field[i] {
+			case '%':
+				arg.WriteByte('%')
+			case 'f', 'u', 'F', 'U':
+				arg.WriteString(strings.Join(paths, " "))
+				used = true
+			}
+		}
+		if field == "" || arg.Len() > 0 {
+			args = append(args, arg.String())
+		}
+	}
+	if !used {
+		args = append(args, paths...)
+	}
+	return args
+}
+
+// splitCommand splits a command line into fields, honouring double and
+// single quotes and backslash escapes.
+func splitCommand(line string) []string {
+	fields := []string{}
+	var field strings.Builder
+	inField := false
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && quote != '\'' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+			inField = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inField = true
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteByte(c)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
@@ -0,0 +1,150 @@
+package dirk
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// handlerNames returns the names of handlers.
+func handlerNames(handlers []Handler) []string {
+	names := []string{}
+	for _, h := range handlers {
+		names = append(names, h.Name)
+	}
+	return names
+}
+
+func TestHandlers(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "doc.pdf"), []byte("%PDF-1.4\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "a.tar.gz"), []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 3}, 0644)
+	a := NewAssociations()
+	a.Add("application/pdf", Handler{Name: "zathura"})
+	a.Add("application/pdf", Handler{Name: "evince"}, Handler{Name: "zathura"})
+	a.Add("application/*", Handler{Name: "xdg-generic"})
+	a.Add(".PDF", Handler{Name: "pdf-by-ext"})
+	a.Add(".gz", Handler{Name: "gzip-by-ext"})
+	a.Add(".tar.gz", Handler{Name: "tar-by-ext"})
+	a.Add("inode/directory", Handler{Name: "files"})
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"doc.pdf", []string{"evince", "zathura", "xdg-generic", "pdf-by-ext"}},
+		{"a.tar.gz", []string{"xdg-generic", "tar-by-ext", "gzip-by-ext"}},
+		{".", []string{"files"}},
+	}
+	for _, test := range tests {
+		f, _ := MakeFile(filepath.Join(dir, test.name))
+		if got := handlerNames(a.Handlers(f)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAssociationsLoad(t *testing.T) {
+	conf := filepath.Join(t.TempDir(), "open.conf")
+	os.WriteFile(conf, []byte("[mime]\napplication/pdf zathura --fork;evince\n[ext]\n.md nvim\n[terminal]\nnvim true\n"), 0644)
+	a := NewAssociations()
+	if err := a.Load(conf); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		pattern string
+		want    []Handler
+	}{
+		{"application/pdf", []Handler{{Name: "zathura", Exec: "zathura --fork"}, {Name: "evince", Exec: "evince"}}},
+		{".md", []Handler{{Name: "nvim", Exec: "nvim", Terminal: true}}},
+	}
+	for _, test := range tests {
+		if got := a.handlers[test.pattern]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestAssociationsLoadXDG(t *testing.T) {
+	dir := t.TempDir()
+	data, config := filepath.Join(dir, "data"), filepath.Join(dir, "config")
+	apps := filepath.Join(data, "applications")
+	os.MkdirAll(filepath.Join(apps, "org"), 0755)
+	os.MkdirAll(config, 0755)
+	desktop := func(name, body string) {
+		os.WriteFile(filepath.Join(apps, name), []byte("[Desktop Entry]\nType=Application\n"+body), 0644)
+	}
+	desktop("viewer.desktop", "Exec=viewer %U\nMimeType=application/pdf;image/png;\n")
+	desktop("org/editor.desktop", "Exec=editor %f\nTerminal=true\nMimeType=application/pdf;\n")
+	desktop("hidden.desktop", "Exec=hidden\nHidden=true\nMimeType=application/pdf;\n")
+	desktop("unwanted.desktop", "Exec=unwanted\nMimeType=image/png;\n")
+	os.WriteFile(filepath.Join(config, "mimeapps.list"), []byte(
+		"[Default Applications]\napplication/pdf=org-editor.desktop\n"+
+			"[Removed Associations]\nimage/png=unwanted.desktop;\n"), 0644)
+	t.Setenv("XDG_DATA_HOME", data)
+	t.Setenv("XDG_DATA_DIRS", filepath.Join(dir, "none"))
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv("XDG_CONFIG_DIRS", filepath.Join(dir, "none"))
+	a := NewAssociations()
+	if err := a.LoadXDG(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		mime string
+		want []string
+	}{
+		{"application/pdf", []string{"org-editor.desktop", "viewer.desktop"}},
+		{"image/png", []string{"viewer.desktop"}},
+	}
+	for _, test := range tests {
+		if got := handlerNames(a.handlers[test.mime]); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.mime, got, test.want)
+		}
+	}
+	if h := a.handlers["application/pdf"][0]; !h.Terminal || h.Exec != "editor %f" {
+		t.Errorf("editor read as %+v", h)
+	}
+}
+
+func TestHandlerCommand(t *testing.T) {
+	paths := []string{"/a b", "/c"}
+	tests := []struct {
+		exec string
+		want []string
+	}{
+		{"viewer", []string{"viewer", "/a b", "/c"}},
+		{"viewer %f", []string{"viewer", "/a b"}},
+		{"viewer %U --new", []string{"viewer", "/a b", "/c", "--new"}},
+		{"viewer --file=%f", []string{"viewer", "--file=/a b /c"}},
+		{`"my viewer" '100%%' %F`, []string{"my viewer", "100%", "/a b", "/c"}},
+		{`viewer a\ b ""`, []string{"viewer", "a b", "", "/a b", "/c"}},
+	}
+	for _, test := range tests {
+		if got := (Handler{Exec: test.exec}).command(paths); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.exec, got, test.want)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	defer func(a *Associations) { DefaultAssociations = a }(DefaultAssociations)
+	dir := t.TempDir()
+	doc := filepath.Join(dir, "doc.pdf")
+	os.WriteFile(doc, []byte("%PDF-1.4\n"), 0644)
+	opened := filepath.Join(dir, "opened")
+	script := filepath.Join(dir, "viewer")
+	os.WriteFile(script, []byte("#!/bin/sh\necho \"$1\" > "+opened+"\n"), 0755)
+	DefaultAssociations = NewAssociations()
+	files, _ := MakeFiles([]string{doc})
+	if err := files.Open(); err == nil || !strings.Contains(err.Error(), "no handler") {
+		t.Fatalf("opening without handler gives %v", err)
+	}
+	DefaultAssociations.Add(".pdf", Handler{Name: "viewer", Exec: script, Terminal: true})
+	if err := files.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(opened); string(content) != doc+"\n" {
+		t.Fatalf("handler got %q", content)
+	}
+}
@@ -0,0 +1,332 @@
+package dirk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Action is one primitive step of a Plan.
+type Action struct {
+	// Kind is one of copy, move, remove, mkdir, rename, archive, extract or
+	// skip.
+	Kind    string   `json:"kind"`
+	Source  string   `json:"source,omitempty"`
+	Sources []string `json:"sources,omitempty"` // Sources are the members of an archive.
+	Target  string   `json:"target,omitempty"`
+	Size    int64    `json:"size"`
+	// Conflict explains why the target differs from the obvious one or why
+	// the action is skipped.
+	Conflict string `json:"conflict,omitempty"`
+}
+
+// Plan is the ordered list of actions an explorer operation will take. It
+// can be inspected, marshalled to JSON, edited and then executed.
+type Plan struct {
+	Op      string   `json:"op"`
+	Actions []Action `json:"actions"`
+
+	claimed map[string]bool
+}
+
+func newPlan(op string) *Plan {
+	return &Plan{Op: op, Actions: []Action{}, claimed: map[string]bool{}}
+}
+
+// Size returns the number of bytes the plan will process.
+func (p *Plan) Size() (size int64) {
+	for _, a := range p.Actions {
+		size += a.Size
+	}
+	return
+}
+
+// Conflicts returns the actions whose target had to be changed or that are
+// skipped.
+func (p *Plan) Conflicts() []Action {
+	conflicts := []Action{}
+	for _, a := range p.Actions {
+		if a.Conflict != "" {
+			conflicts = append(conflicts, a)
+		}
+	}
+	return conflicts
+}
+
+func (p *Plan) add(a Action) { p.Actions = append(p.Actions, a) }
+
+// claim reserves a free name for a new file, numbering it like renameExist
+// when name is taken on disk or by an earlier action of the plan.
+func (p *Plan) claim(name string) (target, conflict string) {
+	return p.claimKeeping(name, "")
+}
+
+// claimKeeping is claim numbering name before suffix, so out.tar.gz becomes
+// out(1).tar.gz and keeps its format.
+func (p *Plan) claimKeeping(name, suffix string) (target, conflict string) {
+	taken := func(name string) bool {
+		_, err := os.Lstat(name)
+		return p.claimed[name] || err == nil
+	}
+	stem := strings.TrimSuffix(name, suffix)
+	target = name
+	for i := 1; taken(target); i++ {
+		target = stem + "(" + strconv.Itoa(i) + ")" + suffix
+	}
+	if target != name {
+		conflict = filepath.Base(name) + " exists, using " + filepath.Base(target)
+	}
+	p.claimed[target] = true
+	return
+}
+
+func (a Action) sources() []string {
+	if a.Source != "" {
+		return append([]string{a.Source}, a.Sources...)
+	}
+	return a.Sources
+}
+
+// Execute carries out the actions in order. It never overwrites: an action
+// whose target already exists fails. The outcome of every action is reported
+// like any other batch operation.
+func (p *Plan) Execute() error {
+	var paths []string
+	for _, a := range p.Actions {
+		if a.Kind != "mkdir" && a.Kind != "skip" {
+			paths = append(paths, a.sources()...)
+		}
+	}
+	tr := newTracker(p.Op, paths...)
+	defer tr.finish()
+	res := &Result{Op: p.Op}
+	for i, a := range p.Actions {
+		path := a.Source
+		if path == "" {
+			path = a.Target
+		}
+		switch err := a.execute(tr); err {
+		case nil:
+			res.Succeeded = append(res.Succeeded, path)
+		case errSkip:
+			res.Skipped = append(res.Skipped, path)
+		default:
+			res.fail(a.Kind, path, err)
+			if !ContinueOnError {
+				for _, rest := range p.Actions[i+1:] {
+					res.Skipped = append(res.Skipped, rest.sources()...)
+				}
+				return res
+			}
+		}
+	}
+	return res.Err()
+}
+
+func (a Action) execute(tr *tracker) error {
+	switch a.Kind {
+	case "skip":
+		return errSkip
+	case "remove":
+		tr.skip(a.Source)
+		return os.RemoveAll(a.Source)
+	case "copy", "move", "mkdir", "rename", "archive", "extract":
+	default:
+		return fmt.Errorf("unknown action %q", a.Kind)
+	}
+	if a.Target == "" {
+		return fmt.Errorf("%s without target", a.Kind)
+	}
+	if _, err := os.Lstat(a.Target); err == nil {
+		return &os.PathError{Op: a.Kind, Path: a.Target, Err: os.ErrExist}
+	}
+	switch a.Kind {
+	case "copy":
+		return cpAny(a.Source, a.Target, tr)
+	case "move":
+		return mvAny(a.Source, a.Target, tr)
+	case "mkdir":
+		return os.MkdirAll(a.Target, 0777)
+	case "rename":
+		return os.Rename(a.Source, a.Target)
+	case "archive":
+		return archive(a.sources(), a.Target, tr)
+	}
+	return extract(a.Source, a.Target)
+}
+
+// PastePlan plans copying the selection into destin.
+func (files Files) PastePlan(destin File) (*Plan, error) {
+	return files.transferPlan("copy", destin.Path)
+}
+
+// MovePlan plans moving the selection into destin.
+func (files Files) MovePlan(destin File) (*Plan, error) {
+	return files.transferPlan("move", destin.Path)
+}
+
+func (files Files) transferPlan(kind, dir string) (*Plan, error) {
+	if len(files) == 0 {
+		return nil, ErrNoSelection
+	}
+	plan := newPlan(kind)
+	plan.transfer(kind, files, dir)
+	return plan, nil
+}
+
+func (p *Plan) transfer(kind string, files Files, dir string) {
+	for _, f := range files {
+		if isVirtual(f.Path) {
+			if _, err := statAny(f.Path); err != nil {
+				p.add(Action{Kind: "skip", Source: f.Path, Conflict: "does not exist"})
+				continue
+			} else if kind != "copy" {
+				p.add(Action{Kind: "skip", Source: f.Path, Conflict: "inside an archive"})
+				continue
+			}
+		} else if _, err := os.Lstat(f.Path); err != nil {
+			p.add(Action{Kind: "skip", Source: f.Path, Conflict: "does not exist"})
+			continue
+		}
+		if kind == "move" && filepath.Clean(filepath.Dir(f.Path)) == filepath.Clean(dir) {
+			p.add(Action{Kind: "skip", Source: f.Path, Conflict: "already in " + dir})
+			continue
+		}
+		size, _ := measure(f.Path)
+		target, conflict := p.claim(filepath.Join(dir, filepath.Base(f.Path)))
+		p.add(Action{Kind: kind, Source: f.Path, Target: target, Size: size, Conflict: conflict})
+	}
+}
+
+// DeletePlan plans removing the selection.
+func (files Files) DeletePlan() (*Plan, error) {
+	if len(files) == 0 {
+		return nil, ErrNoSelection
+	}
+	plan := newPlan("delete")
+	for _, f := range files {
+		if isVirtual(f.Path) {
+			plan.add(Action{Kind: "skip", Source: f.Path, Conflict: "inside an archive"})
+			continue
+		}
+		size, _ := measure(f.Path)
+		plan.add(Action{Kind: "remove", Source: f.Path, Size: size})
+	}
+	return plan, nil
+}
+
+// IndentPlan plans moving the selection into a new folder name next to it.
+func (files Files) IndentPlan(name string) (*Plan, error) {
+	if len(files) == 0 {
+		return nil, ErrNoSelection
+	}
+	plan := newPlan("indent")
+	dir, conflict := plan.claim(filepath.Join(getParentPath(*files[0]), name))
+	plan.add(Action{Kind: "mkdir", Target: dir, Conflict: conflict})
+	plan.transfer("move", files, dir)
+	return plan, nil
+}
+
+// OutdentPlan plans moving the selection one level up, into a new folder
+// there when a name is given.
+func (files Files) OutdentPlan(name ...string) (*Plan, error) {
+	if len(files) == 0 {
+		return nil, ErrNoSelection
+	}
+	plan := newPlan("outdent")
+	dir := filepath.Dir(filepath.Dir(filepath.Clean(files[0].Path)))
+	if len(name) > 0 && name[0] != "" {
+		var conflict string
+		dir, conflict = plan.claim(filepath.Join(dir, name[0]))
+		plan.add(Action{Kind: "mkdir", Target: dir, Conflict: conflict})
+	}
+	plan.transfer("move", files, dir)
+	return plan, nil
+}
+
+// RenamePlan plans giving the selected files the names, in order, in their
+// own folders. Taken names are numbered, and swaps are done through a
+// temporary name.
+func (files Files) RenamePlan(names ...string) (*Plan, error) {
+	if len(files) == 0 {
+		return nil, ErrNoSelection
+	}
+	if len(files) != len(names) {
+		return nil, fmt.Errorf("Number of files and names don't match")
+	}
+	plan := newPlan("rename")
+	sources := map[string]bool{}
+	for _, f := range files {
+		sources[f.Path] = true
+	}
+	renames := Renames{}
+	for i, f := range files {
+		to := filepath.Join(filepath.Dir(f.Path), names[i])
+		if to == f.Path {
+			continue
+		}
+		conflict := ""
+		if !sources[to] {
+			to, conflict = plan.claim(to)
+		}
+		renames = append(renames, Rename{From: f.Path, To: to, Conflict: conflict})
+	}
+	for _, r := range renames.order() {
+		plan.add(Action{Kind: "rename", Source: r.From, Target: r.To, Conflict: r.Conflict})
+	}
+	return plan, nil
+}
+
+// Plan turns a rename plan into a generic Plan.
+func (rs Renames) Plan() *Plan {
+	plan := newPlan("rename")
+	for _, r := range rs {
+		plan.add(Action{Kind: "rename", Source: r.From, Target: r.To, Conflict: r.Conflict})
+	}
+	return plan
+}
+
+// ArchivePlan plans packing the whole selection into one archive called
+// name, in the common parent of the selected files and with paths relative
+// to it.
+func (files Files) ArchivePlan(name string) (*Plan, error) {
+	if len(files) == 0 {
+		return nil, ErrNoSelection
+	}
+	kind := archiveKind(name)
+	if kind == "" {
+		return nil, fmt.Errorf("Not a proper archive name")
+	}
+	plan := newPlan("archive")
+	sources, size := files.paths(), int64(0)
+	for _, source := range sources {
+		bytes, _ := measure(source)
+		size += bytes
+	}
+	target, conflict := plan.claimKeeping(filepath.Join(commonParent(sources), name), archiveSuffix(name))
+	tarred, _ := splitKind(kind)
+	if !tarred && kind != "zip" && (len(files) > 1 || files[0].IsDir()) {
+		conflict = kind + " holds a single file"
+	}
+	plan.add(Action{Kind: "archive", Sources: sources, Target: target, Size: size, Conflict: conflict})
+	return plan, nil
+}
+
+// UnarchivePlan plans extracting every selected archive into a folder called
+// name next to it.
+func (files Files) UnarchivePlan(name string) (*Plan, error) {
+	if len(files) == 0 {
+		return nil, ErrNoSelection
+	}
+	plan := newPlan("extract")
+	for _, f := range files {
+		target, conflict := plan.claim(filepath.Join(getParentPath(*f), name))
+		if detectArchive(f.Path) == "" {
+			conflict = "not an archive"
+		}
+		plan.add(Action{Kind: "extract", Source: f.Path, Target: target, Size: f.File.Size(), Conflict: conflict})
+	}
+	return plan, nil
+}
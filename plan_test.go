@@ -0,0 +1,162 @@
+package dirk
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPlanDir makes a folder holding the files a and b and an empty folder
+// out.
+func testPlanDir(t *testing.T) (dir string, files Files, out File) {
+	dir = t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a"), []byte("aaa"), 0644)
+	os.WriteFile(filepath.Join(dir, "b"), []byte("b"), 0644)
+	os.Mkdir(filepath.Join(dir, "out"), 0755)
+	files, _ = MakeFiles([]string{filepath.Join(dir, "a"), filepath.Join(dir, "b")})
+	out, _ = MakeFile(filepath.Join(dir, "out"))
+	return
+}
+
+func TestPlans(t *testing.T) {
+	tests := []struct {
+		name    string
+		plan    func(files Files, out File) (*Plan, error)
+		kinds   []string
+		targets []string // targets are relative to the folder, "" for none
+		after   map[string]string
+	}{
+		{"paste", func(files Files, out File) (*Plan, error) { return files.PastePlan(out) },
+			[]string{"copy", "copy"}, []string{"out/a", "out/b"},
+			map[string]string{"a": "aaa", "out/a": "aaa", "out/b": "b"}},
+		{"move", func(files Files, out File) (*Plan, error) { return files.MovePlan(out) },
+			[]string{"move", "move"}, []string{"out/a", "out/b"},
+			map[string]string{"a": "", "out/a": "aaa", "out/b": "b"}},
+		{"delete", func(files Files, _ File) (*Plan, error) { return files.DeletePlan() },
+			[]string{"remove", "remove"}, []string{"", ""},
+			map[string]string{"a": "", "b": ""}},
+		{"indent", func(files Files, _ File) (*Plan, error) { return files.IndentPlan("new") },
+			[]string{"mkdir", "move", "move"}, []string{"new", "new/a", "new/b"},
+			map[string]string{"a": "", "new/a": "aaa", "new/b": "b"}},
+		{"rename swap", func(files Files, _ File) (*Plan, error) { return files.RenamePlan("b", "a") },
+			[]string{"rename", "rename", "rename"}, nil,
+			map[string]string{"a": "b", "b": "aaa"}},
+		{"archive", func(files Files, _ File) (*Plan, error) { return files.ArchivePlan("both.zip") },
+			[]string{"archive"}, []string{"both.zip"},
+			map[string]string{"a": "aaa"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, files, out := testPlanDir(t)
+			plan, err := test.plan(files, out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(plan.Actions) != len(test.kinds) {
+				t.Fatalf("got %+v", plan.Actions)
+			}
+			for i, a := range plan.Actions {
+				if a.Kind != test.kinds[i] {
+					t.Errorf("action %d is a %s, want %s", i, a.Kind, test.kinds[i])
+				}
+				if test.targets != nil && a.Target != filepath.Join(dir, test.targets[i]) && (a.Target != "" || test.targets[i] != "") {
+					t.Errorf("action %d targets %s, want %s", i, a.Target, test.targets[i])
+				}
+			}
+			if len(plan.Conflicts()) > 0 {
+				t.Errorf("conflicts %+v", plan.Conflicts())
+			}
+			if err := plan.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range test.after {
+				content, err := os.ReadFile(filepath.Join(dir, name))
+				if string(content) != want || (want == "") != os.IsNotExist(err) {
+					t.Errorf("%s: got %q, %v, want %q", name, content, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanConflicts(t *testing.T) {
+	dir, files, out := testPlanDir(t)
+	os.WriteFile(filepath.Join(dir, "out", "a"), []byte("taken"), 0644)
+	files = append(files, &File{Path: filepath.Join(dir, "gone"), Name: "gone"})
+	plan, _ := files.PastePlan(out)
+	tests := []struct {
+		kind, target string
+		conflict     bool
+	}{
+		{"copy", "out/a(1)", true},
+		{"copy", "out/b", false},
+		{"skip", "", true},
+	}
+	for i, test := range tests {
+		a := plan.Actions[i]
+		target := ""
+		if a.Target != "" {
+			target, _ = filepath.Rel(dir, a.Target)
+		}
+		if a.Kind != test.kind || target != test.target || (a.Conflict != "") != test.conflict {
+			t.Errorf("action %d: got %+v", i, a)
+		}
+	}
+	if plan.Size() != 4 {
+		t.Errorf("plan of %d bytes", plan.Size())
+	}
+	// skipped actions are no failure
+	if err := plan.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "out", "a")); string(content) != "taken" {
+		t.Fatal("existing file overwritten")
+	}
+}
+
+func TestPlanEdited(t *testing.T) {
+	dir, files, out := testPlanDir(t)
+	plan, _ := files.PastePlan(out)
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var edited Plan
+	if err := json.Unmarshal(data, &edited); err != nil {
+		t.Fatal(err)
+	}
+	if len(edited.Actions) != 2 || edited.Op != "copy" {
+		t.Fatalf("unmarshalled %+v", edited)
+	}
+	// copy a under another name and b not at all
+	edited.Actions[0].Target = filepath.Join(dir, "out", "renamed")
+	edited.Actions[1].Kind = "skip"
+	if err := edited.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "out", "renamed")); string(content) != "aaa" {
+		t.Fatalf("renamed holds %q", content)
+	}
+	if rest := leftovers(filepath.Join(dir, "out"), "renamed"); len(rest) > 0 {
+		t.Fatalf("out holds %v", rest)
+	}
+
+	tests := []struct {
+		name   string
+		action Action
+	}{
+		{"existing target", Action{Kind: "copy", Source: filepath.Join(dir, "a"), Target: filepath.Join(dir, "b")}},
+		{"no target", Action{Kind: "copy", Source: filepath.Join(dir, "a")}},
+		{"unknown kind", Action{Kind: "shred", Source: filepath.Join(dir, "a"), Target: filepath.Join(dir, "c")}},
+	}
+	for _, test := range tests {
+		plan := &Plan{Op: "edited", Actions: []Action{test.action}}
+		if err := plan.Execute(); err == nil {
+			t.Errorf("%s: executed", test.name)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "b")); string(content) != "b" {
+		t.Fatal("b overwritten")
+	}
+}
@@ -0,0 +1,230 @@
+package dirk
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	TabWidth         = 4  // TabWidth is the tab stop used by text previews.
+	PreviewCacheSize = 64 // PreviewCacheSize is how many previews are kept.
+)
+
+type previewKey struct {
+	path          string
+	mtime         int64
+	size          int64
+	width, height int
+}
+
+var previews = struct {
+	sync.Mutex
+	data  map[previewKey]string
+	order []previewKey
+}{data: map[previewKey]string{}}
+
+// Preview renders the file as at most height lines of at most width
+// columns, chosen by its MIME type: the first lines of text, a summary of a
+// folder, the listing of an archive, the metadata of an
+// image, audio or video file and a hex dump of anything else. A width or
+// height of zero or less means no limit. Previews are cached until the file
+// is modified.
+func (f File) Preview(width, height int) (string, error) {
+	info, err := statAny(f.Path)
+	if err != nil {
+		return "", err
+	}
+	key := previewKey{f.Path, info.ModTime().UnixNano(), info.Size(), width, height}
+	previews.Lock()
+	preview, ok := previews.data[key]
+	previews.Unlock()
+	if ok {
+		return preview, nil
+	}
+
+	lines, err := previewLines(f, info, width, height)
+	if err != nil {
+		return "", err
+	}
+	if height > 0 && len(lines) > height {
+		lines = lines[:height]
+	}
+	for i := range lines {
+		lines[i] = fitWidth(lines[i], width)
+	}
+	preview = strings.Join(lines, "\n")
+
+	previews.Lock()
+	defer previews.Unlock()
+	if _, ok := previews.data[key]; !ok {
+		previews.data[key] = preview
+		previews.order = append(previews.order, key)
+		for len(previews.order) > PreviewCacheSize {
+			delete(previews.data, previews.order[0])
+			previews.order = previews.order[1:]
+		}
+	}
+	return preview, nil
+}
+
+func previewLines(f File, info os.FileInfo, width, height int) ([]string, error) {
+	if info.IsDir() {
+		return previewDir(f.Path, height)
+	}
+	if !isVirtual(f.Path) && detectArchive(f.Path) != "" {
+		return previewArchive(f.Path, height)
+	}
+	mime := f.MimeType()
+	switch mime[0] {
+	case "text":
+		return previewText(f.Path, height)
+	case "image", "audio", "video":
+		return previewMeta(f.Path, info, strings.Join(mime, "/")), nil
+	}
+	return previewHex(f.Path, width, height)
+}
+
+func previewText(path string, height int) ([]string, error) {
+	file, err := openAny(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() && (height <= 0 || len(lines) < height) {
+		lines = append(lines, expandTabs(strings.TrimSuffix(scanner.Text(), "\r")))
+	}
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return nil, err
+	}
+	return lines, nil
+}
+
+func previewDir(path string, height int) ([]string, error) {
+	infos, err := readDirAny(path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].IsDir() != infos[j].IsDir() {
+			return infos[i].IsDir()
+		}
+		return infos[i].Name() < infos[j].Name()
+	})
+	folders, files, size := 0, 0, int64(0)
+	names := []string{}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() {
+			folders++
+			name += "/"
+		} else {
+			files++
+			size += info.Size()
+		}
+		names = append(names, name)
+	}
+	lines := []string{fmt.Sprintf("%d folders, %d files, %s", folders, files, byteCountSI(size))}
+	return append(lines, names...), nil
+}
+
+func previewArchive(path string, height int) ([]string, error) {
+	lines := []string{}
+	err := eachEntry(path, func(e ArchiveEntry) error {
+		if e.IsDir() || e.Size < 0 {
+			lines = append(lines, e.Name)
+		} else {
+			lines = append(lines, fmt.Sprintf("%s  %s", e.Name, byteCountSI(e.Size)))
+		}
+		if height > 0 && len(lines) >= height {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err == filepath.SkipDir {
+		err = nil
+	}
+	return lines, err
+}
+
+func previewMeta(path string, info os.FileInfo, mime string) []string {
+	lines := []string{
+		"Type:     " + mime,
+		"Size:     " + byteCountSI(info.Size()),
+		"Modified: " + info.ModTime().Format(time.RFC1123),
+		"Mode:     " + info.Mode().String(),
+	}
+	if strings.HasPrefix(mime, "image/") {
+		if file, err := os.Open(path); err == nil {
+			config, format, err := image.DecodeConfig(file)
+			file.Close()
+			if err == nil {
+				lines = append(lines, fmt.Sprintf("Image:    %dx%d %s", config.Width, config.Height, format))
+			}
+		}
+	}
+	return lines
+}
+
+func previewHex(path string, width, height int) ([]string, error) {
+	file, err := openAny(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	// fewer bytes per line in narrow panes, a line takes 13+4*bytes columns
+	bytes := 16
+	for width > 0 && bytes > 4 && 13+4*bytes > width {
+		bytes /= 2
+	}
+	limit := int64(height * bytes)
+	if height <= 0 {
+		limit = 4096
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(file, limit))
+	if err != nil {
+		return nil, err
+	}
+	return hexLines(data, 0, bytes, 1), nil
+}
+
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") || TabWidth < 1 {
+		return line
+	}
+	var out strings.Builder
+	column := 0
+	for _, r := range line {
+		if r == '\t' {
+			spaces := TabWidth - column%TabWidth
+			out.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+			continue
+		}
+		out.WriteRune(r)
+		column++
+	}
+	return out.String()
+}
+
+func fitWidth(line string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:width])
+}
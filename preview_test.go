@@ -0,0 +1,80 @@
+package dirk
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreview(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "text.txt"), []byte("one\ta\r\ntwo\nthree\nfour\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "binary"), []byte{0, 1, 2, 0xff, 'A', 'B', 0, 0, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17}, 0644)
+	f, _ := os.Create(filepath.Join(dir, "picture.png"))
+	png.Encode(f, image.NewGray(image.Rect(0, 0, 3, 2)))
+	f.Close()
+	os.MkdirAll(filepath.Join(dir, "folder", "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "folder", "b"), make([]byte, 1000), 0644)
+	os.WriteFile(filepath.Join(dir, "folder", "a"), nil, 0644)
+	if err := archive([]string{filepath.Join(dir, "folder")}, filepath.Join(dir, "folder.tar.gz"), nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		width, height int
+		want          []string // want holds the lines, or their prefixes
+	}{
+		{"text.txt", 0, 0, []string{"one a", "two", "three", "four"}},
+		{"text.txt", 4, 2, []string{"one ", "two"}},
+		{"folder", 0, 0, []string{"1 folders, 2 files, 1.0 kB", "sub/", "a", "b"}},
+		{"folder", 0, 2, []string{"1 folders, 2 files", "sub/"}},
+		{"folder.tar.gz", 0, 0, []string{"folder/", "folder/a  0 B", "folder/b  1.0 kB", "folder/sub/"}},
+		{"picture.png", 0, 0, []string{"Type:     image/png", "Size:", "Modified:", "Mode:", "Image:    3x2 png"}},
+		{"binary", 0, 0, []string{"00000000  00 01 02 ff 41 42 00 00", "00000010  0f 10 11 "}},
+		{"binary", 45, 1, []string{"00000000  00 01 02 ff 41 42 00 00  |....AB..|"}},
+	}
+	for _, test := range tests {
+		file, _ := MakeFile(filepath.Join(dir, test.name))
+		preview, err := file.Preview(test.width, test.height)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		lines := strings.Split(preview, "\n")
+		if len(lines) != len(test.want) {
+			t.Errorf("%s %dx%d: got %q", test.name, test.width, test.height, preview)
+			continue
+		}
+		for i, want := range test.want {
+			if !strings.HasPrefix(lines[i], want) {
+				t.Errorf("%s %dx%d: line %d is %q, want %q", test.name, test.width, test.height, i, lines[i], want)
+			}
+		}
+	}
+}
+
+func TestPreviewCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "text.txt")
+	os.WriteFile(path, []byte("old\n"), 0644)
+	file, _ := MakeFile(path)
+	if preview, _ := file.Preview(0, 0); preview != "old" {
+		t.Fatalf("got %q", preview)
+	}
+	// the cache answers as long as the file looks unchanged
+	then := time.Now().Add(-time.Hour)
+	os.Chtimes(path, then, then)
+	file.Preview(0, 0)
+	os.WriteFile(path, []byte("new\n"), 0644)
+	os.Chtimes(path, then, then)
+	if preview, _ := file.Preview(0, 0); preview != "old" {
+		t.Fatalf("cached preview %q", preview)
+	}
+	os.Chtimes(path, time.Now(), time.Now())
+	if preview, _ := file.Preview(0, 0); preview != "new" {
+		t.Fatalf("modified file previews as %q", preview)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 	"unicode/utf8"
@@ -202,3 +204,220 @@ func (s *Spinner) erase() {
 	}
 	s.lastOutput = ""
 }
+
+// ProgressEvent describes how far a long-running file operation has got.
+type ProgressEvent struct {
+	Op         string        // copy, move, delete, archive, encrypt or decrypt
+	Path       string        // file currently being processed
+	BytesDone  int64         // bytes processed so far
+	BytesTotal int64         // bytes the operation will process
+	FilesDone  int           // files processed so far
+	FilesTotal int           // files the operation will process
+	Speed      float64       // average throughput in bytes per second
+	ETA        time.Duration // estimated time left
+	Done       bool          // set on the last event of an operation
+}
+
+// Percent returns the completed share of the operation, by bytes when the
+// total size is known and by files otherwise.
+func (e ProgressEvent) Percent() int {
+	switch {
+	case e.Done:
+		return 100
+	case e.BytesTotal > 0:
+		return int(e.BytesDone * 100 / e.BytesTotal)
+	case e.FilesTotal > 0:
+		return e.FilesDone * 100 / e.FilesTotal
+	}
+	return 0
+}
+
+// ProgressFunc is called with progress events while an operation runs.
+type ProgressFunc func(ProgressEvent)
+
+var (
+	// OnProgress receives progress events from Paste, Move, Delete,
+	// Indent, Outdent, Archive, Encrypt and Decrypt when set.
+	OnProgress ProgressFunc
+	// ProgressInterval is the minimum time between two events of the same
+	// operation, apart from the first and the last.
+	ProgressInterval = 100 * time.Millisecond
+)
+
+// tracker accumulates the progress of one operation. A nil tracker is valid
+// and does nothing, so callers never need to check whether anyone listens.
+type tracker struct {
+	lock  sync.Mutex
+	fn    ProgressFunc
+	ev    ProgressEvent
+	start time.Time
+	last  time.Time
+}
+
+func newTracker(op string, paths ...string) *tracker {
+	if OnProgress == nil {
+		return nil
+	}
+	tr := &tracker{fn: OnProgress, start: time.Now(), ev: ProgressEvent{Op: op}}
+	for _, path := range paths {
+		bytes, files := measure(path)
+		tr.ev.BytesTotal += bytes
+		tr.ev.FilesTotal += files
+	}
+	tr.emit(true)
+	return tr
+}
+
+// measure sums the size and number of the regular files under path.
+func measure(path string) (bytes int64, files int) {
+	if bytes, files, ok := measureVirtual(path); ok {
+		return bytes, files
+	}
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			bytes += info.Size()
+			files++
+		}
+		return nil
+	})
+	return
+}
+
+func (tr *tracker) emit(force bool) {
+	now := time.Now()
+	if !force && now.Sub(tr.last) < ProgressInterval {
+		return
+	}
+	tr.last = now
+	if elapsed := now.Sub(tr.start).Seconds(); elapsed > 0 {
+		tr.ev.Speed = float64(tr.ev.BytesDone) / elapsed
+	}
+	if tr.ev.Speed > 0 && tr.ev.BytesTotal > tr.ev.BytesDone {
+		left := float64(tr.ev.BytesTotal-tr.ev.BytesDone) / tr.ev.Speed
+		tr.ev.ETA = time.Duration(left * float64(time.Second))
+	} else {
+		tr.ev.ETA = 0
+	}
+	tr.fn(tr.ev)
+}
+
+// file marks path as the file being worked on.
+func (tr *tracker) file(path string) {
+	if tr == nil {
+		return
+	}
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	tr.ev.Path = path
+	tr.emit(false)
+}
+
+// add counts n more bytes as processed.
+func (tr *tracker) add(n int64) {
+	if tr == nil {
+		return
+	}
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	tr.ev.BytesDone += n
+	tr.emit(false)
+}
+
+// fileDone counts one more file as processed.
+func (tr *tracker) fileDone() {
+	if tr == nil {
+		return
+	}
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	tr.ev.FilesDone++
+	tr.emit(false)
+}
+
+// skip counts everything under path as processed in one step, for work such
+// as a rename or a removal that does not go through the data.
+func (tr *tracker) skip(path string) {
+	if tr == nil {
+		return
+	}
+	bytes, files := measure(path)
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	tr.ev.Path = path
+	tr.ev.BytesDone += bytes
+	tr.ev.FilesDone += files
+	tr.emit(false)
+}
+
+func (tr *tracker) finish() {
+	if tr == nil {
+		return
+	}
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	tr.ev.Done = true
+	tr.emit(true)
+}
+
+// Write lets a tracker count the bytes passing through an io.TeeReader.
+func (tr *tracker) Write(p []byte) (int, error) {
+	tr.add(int64(len(p)))
+	return len(p), nil
+}
+
+func (tr *tracker) reader(r io.Reader) io.Reader {
+	if tr == nil {
+		return r
+	}
+	return io.TeeReader(r, tr)
+}
+
+// Progress fills the spinner's Percent and Suffix from a progress event, so
+// a Spinner can be used as an OnProgress observer.
+func (s *Spinner) Progress(ev ProgressEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Percent = ev.Percent()
+	s.Suffix = fmt.Sprintf(" %3d%% %d/%d %s", s.Percent, ev.FilesDone, ev.FilesTotal, filepath.Base(ev.Path))
+}
+
+// ProgressBar renders progress events as a single, redrawn terminal line.
+type ProgressBar struct {
+	Width  int       // Width is the number of cells of the bar itself
+	Full   string    // Full is the cell used for the completed part
+	Empty  string    // Empty is the cell used for the remaining part
+	Writer io.Writer // Writer defaults to the colored stdout
+	lock   sync.Mutex
+}
+
+// NewProgressBar returns a bar of the given width writing to the terminal.
+func NewProgressBar(width int) *ProgressBar {
+	return &ProgressBar{Width: width, Full: "█", Empty: "░", Writer: color.Output}
+}
+
+// Update draws ev. It has the signature of a ProgressFunc:
+//
+//	dirk.OnProgress = dirk.NewProgressBar(30).Update
+func (b *ProgressBar) Update(ev ProgressEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	percent := ev.Percent()
+	if percent > 100 {
+		percent = 100
+	}
+	full := b.Width * percent / 100
+	bar := strings.Repeat(b.Full, full) + strings.Repeat(b.Empty, b.Width-full)
+	line := fmt.Sprintf("\r%s %s %3d%% %s/%s %d/%d %s/s", ev.Op, bar, percent,
+		byteCountSI(ev.BytesDone), byteCountSI(ev.BytesTotal),
+		ev.FilesDone, ev.FilesTotal, byteCountSI(int64(ev.Speed)))
+	if ev.ETA > 0 {
+		line += " ETA " + ev.ETA.Round(time.Second).String()
+	}
+	if ev.Path != "" && !ev.Done {
+		line += " " + filepath.Base(ev.Path)
+	}
+	fmt.Fprint(b.Writer, line+"\033[K")
+	if ev.Done {
+		fmt.Fprintln(b.Writer)
+	}
+}
@@ -0,0 +1,122 @@
+package dirk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProgressEvents(t *testing.T) {
+	defer func(fn ProgressFunc) { OnProgress = fn }(OnProgress)
+	defer func(k KDF) { KeyDerivation = k }(KeyDerivation)
+	KeyDerivation = PBKDF2(1000)
+	tests := []struct {
+		op  string
+		run func(src, dir string) error
+	}{
+		{"copy", func(src, dir string) error {
+			files, _ := MakeFiles([]string{src})
+			destin, _ := MakeFile(dir)
+			return files.Paste(destin)
+		}},
+		{"move", func(src, dir string) error {
+			files, _ := MakeFiles([]string{src})
+			destin, _ := MakeFile(dir)
+			return files.Move(destin)
+		}},
+		{"delete", func(src, _ string) error {
+			files, _ := MakeFiles([]string{src})
+			return files.Delete()
+		}},
+		{"archive", func(src, _ string) error {
+			files, _ := MakeFiles([]string{src})
+			return files.Archive("tree.tar.gz")
+		}},
+		{"encrypt", func(src, _ string) error {
+			return Encrypt(filepath.Join(src, "big"), testPassword)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.op, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "tree")
+			os.MkdirAll(filepath.Join(src, "sub"), 0755)
+			os.WriteFile(filepath.Join(src, "sub", "small"), []byte("hello"), 0644)
+			os.WriteFile(filepath.Join(src, "big"), make([]byte, 3<<20), 0644)
+			destin := filepath.Join(dir, "destin")
+			os.Mkdir(destin, 0755)
+			var events []ProgressEvent
+			OnProgress = func(ev ProgressEvent) { events = append(events, ev) }
+			if err := test.run(src, destin); err != nil {
+				t.Fatal(err)
+			}
+			if len(events) < 2 {
+				t.Fatalf("got %d events", len(events))
+			}
+			first, last := events[0], events[len(events)-1]
+			if first.Op != test.op || first.BytesTotal == 0 || first.FilesTotal == 0 || first.Done {
+				t.Fatalf("first event %+v", first)
+			}
+			if !last.Done || last.BytesDone != last.BytesTotal || last.FilesDone != last.FilesTotal || last.Percent() != 100 {
+				t.Fatalf("last event %+v", last)
+			}
+			for i := 1; i < len(events); i++ {
+				if events[i].BytesDone < events[i-1].BytesDone || events[i].FilesDone < events[i-1].FilesDone {
+					t.Fatalf("progress went back from %+v to %+v", events[i-1], events[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProgressWithoutObserver(t *testing.T) {
+	defer func(fn ProgressFunc) { OnProgress = fn }(OnProgress)
+	OnProgress = nil
+	// a nil tracker takes every call
+	tr := newTracker("copy", t.TempDir())
+	tr.file("x")
+	tr.add(10)
+	tr.fileDone()
+	tr.skip("x")
+	tr.finish()
+	if tr != nil || tr.reader(strings.NewReader("")) == nil {
+		t.Fatal("tracker without observer")
+	}
+}
+
+func TestProgressPercent(t *testing.T) {
+	tests := []struct {
+		ev   ProgressEvent
+		want int
+	}{
+		{ProgressEvent{}, 0},
+		{ProgressEvent{BytesDone: 50, BytesTotal: 200, FilesDone: 3, FilesTotal: 4}, 25},
+		{ProgressEvent{FilesDone: 3, FilesTotal: 4}, 75},
+		{ProgressEvent{BytesDone: 10, BytesTotal: 200, Done: true}, 100},
+	}
+	for _, test := range tests {
+		if got := test.ev.Percent(); got != test.want {
+			t.Errorf("%+v: got %d, want %d", test.ev, got, test.want)
+		}
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	var buf bytes.Buffer
+	bar := NewProgressBar(10)
+	bar.Writer = &buf
+	bar.Update(ProgressEvent{Op: "copy", Path: "/a/file", BytesDone: 500, BytesTotal: 1000, FilesDone: 1, FilesTotal: 2})
+	line := buf.String()
+	for _, want := range []string{"copy", "█████░░░░░", " 50%", "1/2", "file"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("%q lacks %q", line, want)
+		}
+	}
+	buf.Reset()
+	bar.Update(ProgressEvent{Op: "copy", Path: "/a/file", BytesDone: 1000, BytesTotal: 1000, Done: true})
+	if line := buf.String(); !strings.Contains(line, "100%") || !strings.HasSuffix(line, "\n") || strings.Contains(line, "file") {
+		t.Errorf("last line %q", line)
+	}
+}
@@ -0,0 +1,376 @@
+package dirk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Renamer describes a batch rename by pattern, e.g. every IMG_*.jpg to
+// 2024-trip-{n:03}.jpg.
+//
+// Template is the new name. Everything in braces is replaced:
+//
+//	{n} {n:03}        counter, optionally zero padded to a width
+//	{file}            original name
+//	{name} {ext}      original name without extension, extension without dot
+//	{0} {1} ...       whole match and groups of Regex, or the wildcards of Match
+//	{mtime:layout}    modification time in a Go time layout (2006-01-02)
+//	{date:layout}     EXIF date taken, falling back to the modification time
+//
+// Any token can be followed by |upper, |lower or |title to change its case,
+// as in {name|lower}.
+type Renamer struct {
+	Match    string         // Match is a shell pattern files must match.
+	Regex    *regexp.Regexp // Regex is used instead of Match when set.
+	Template string
+	Start    int // Start is the first value of {n}; zero means 1.
+	Step     int // Step is added to {n} after each file; zero means 1.
+}
+
+// Rename is one step of a rename plan.
+type Rename struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Conflict string `json:"conflict,omitempty"`
+}
+
+// Renames is an ordered rename plan, as made by Renamer.Plan.
+type Renames []Rename
+
+var tokenRegex = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// Plan works out the new name of every matching file without touching the
+// disk. Clashing targets are reported in the Conflict field of their step.
+// Renames that depend on each other, like a→b with b→c, are ordered so that
+// no name is overwritten, and cycles such as a→b, b→a go through a temporary
+// name.
+func (r Renamer) Plan(files Files) (Renames, error) {
+	re := r.Regex
+	if re == nil {
+		var err error
+		if re, err = globRegex(r.Match); err != nil {
+			return nil, err
+		}
+	}
+	n, step := r.Start, r.Step
+	if n == 0 {
+		n = 1
+	}
+	if step == 0 {
+		step = 1
+	}
+	plan := Renames{}
+	for _, f := range files {
+		groups := re.FindStringSubmatch(f.Name)
+		if groups == nil {
+			continue
+		}
+		name, err := r.expand(f, groups, n)
+		if err != nil {
+			return nil, err
+		}
+		n += step
+		to := filepath.Join(filepath.Dir(f.Path), name)
+		if to == f.Path {
+			continue
+		}
+		ren := Rename{From: f.Path, To: to}
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			ren.Conflict = "invalid name " + strconv.Quote(name)
+		}
+		plan = append(plan, ren)
+	}
+	return plan.order(), nil
+}
+
+func (r Renamer) expand(f *File, groups []string, n int) (string, error) {
+	var err error
+	name := tokenRegex.ReplaceAllStringFunc(r.Template, func(token string) string {
+		parts := strings.Split(token[1:len(token)-1], "|")
+		key, arg := parts[0], ""
+		if i := strings.Index(key, ":"); i >= 0 {
+			key, arg = key[:i], key[i+1:]
+		}
+		var value string
+		ext := filepath.Ext(f.Name)
+		switch key {
+		case "n":
+			value = strconv.Itoa(n)
+			if width, e := strconv.Atoi(arg); e == nil && len(value) < width {
+				value = strings.Repeat("0", width-len(value)) + value
+			}
+		case "file":
+			value = f.Name
+		case "name":
+			value = strings.TrimSuffix(f.Name, ext)
+		case "ext":
+			value = strings.TrimPrefix(ext, ".")
+		case "mtime", "date":
+			if arg == "" {
+				arg = "2006-01-02"
+			}
+			when := f.File.ModTime()
+			if key == "date" {
+				if taken, ok := exifDate(f.Path); ok {
+					when = taken
+				}
+			}
+			value = when.Format(arg)
+		default:
+			i, e := strconv.Atoi(key)
+			if e != nil || i >= len(groups) {
+				err = fmt.Errorf("unknown template token %s", token)
+				return token
+			}
+			value = groups[i]
+		}
+		for _, transform := range parts[1:] {
+			switch transform {
+			case "upper":
+				value = strings.ToUpper(value)
+			case "lower":
+				value = strings.ToLower(value)
+			case "title":
+				value = title(value)
+			default:
+				err = fmt.Errorf("unknown case transform %s", transform)
+			}
+		}
+		return value
+	})
+	return name, err
+}
+
+func title(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		defer func() { prev = r }()
+		if unicode.IsLetter(prev) || unicode.IsDigit(prev) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, s)
+}
+
+// globRegex turns a shell pattern into an anchored regular expression with
+// one group per wildcard.
+func globRegex(glob string) (*regexp.Regexp, error) {
+	if glob == "" {
+		glob = "*"
+	}
+	if _, err := filepath.Match(glob, ""); err != nil {
+		return nil, err
+	}
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			expr.WriteString("(.*)")
+		case '?':
+			expr.WriteString("(.)")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				return nil, filepath.ErrBadPattern
+			}
+			class := strings.Replace(glob[i+1:i+end], "!", "^", 1)
+			expr.WriteString("([" + class + "])")
+			i += end
+		case '\\':
+			i++
+			if i < len(glob) {
+				expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// order marks clashing targets and sorts the renames so that every target
+// is free by the time its rename runs.
+func (rs Renames) order() Renames {
+	sources := map[string]bool{}
+	for _, r := range rs {
+		sources[r.From] = true
+	}
+	targets := map[string]string{}
+	for i := range rs {
+		r := &rs[i]
+		if other, ok := targets[r.To]; ok && r.Conflict == "" {
+			r.Conflict = "same new name as " + other
+		} else if _, err := os.Lstat(r.To); err == nil && !sources[r.To] && r.Conflict == "" {
+			r.Conflict = "file exists"
+		}
+		targets[r.To] = r.From
+	}
+	if len(rs.Conflicts()) > 0 {
+		return rs
+	}
+	pending := append(Renames{}, rs...)
+	ordered := Renames{}
+	for len(pending) > 0 {
+		busy := map[string]bool{}
+		for _, r := range pending {
+			busy[r.From] = true
+		}
+		rest := pending[:0]
+		for _, r := range pending {
+			if busy[r.To] {
+				rest = append(rest, r)
+				continue
+			}
+			ordered = append(ordered, r)
+			delete(busy, r.From)
+		}
+		if len(rest) == len(pending) {
+			// every name is still taken, so this is a cycle: park one file
+			tmp := renameExist(filepath.Join(filepath.Dir(rest[0].From), ".rename-"+filepath.Base(rest[0].From)))
+			ordered = append(ordered, Rename{From: rest[0].From, To: tmp})
+			rest[0].From = tmp
+		}
+		pending = rest
+	}
+	return ordered
+}
+
+// Conflicts returns the renames that cannot be done.
+func (rs Renames) Conflicts() Renames {
+	conflicts := Renames{}
+	for _, r := range rs {
+		if r.Conflict != "" {
+			conflicts = append(conflicts, r)
+		}
+	}
+	return conflicts
+}
+
+// Apply carries out the plan in order. It refuses to start when the plan
+// has conflicts.
+func (rs Renames) Apply() error {
+	if conflicts := rs.Conflicts(); len(conflicts) > 0 {
+		res := &Result{Op: "rename"}
+		for _, r := range conflicts {
+			res.fail("rename", r.From, fmt.Errorf("%s", r.Conflict))
+		}
+		return res
+	}
+	res := &Result{Op: "rename"}
+	for i, r := range rs {
+		if err := os.Rename(r.From, r.To); err != nil {
+			res.fail("rename", r.From, err)
+			for _, rest := range rs[i+1:] {
+				res.Skipped = append(res.Skipped, rest.From)
+			}
+			break
+		}
+		res.Succeeded = append(res.Succeeded, r.From)
+	}
+	return res.Err()
+}
+
+// RenameBy plans and applies a pattern rename on the selection.
+func (files Files) RenameBy(r Renamer) error {
+	if len(files) == 0 {
+		return ErrNoSelection
+	}
+	plan, err := r.Plan(files)
+	if err != nil {
+		return err
+	}
+	return plan.Apply()
+}
+
+// exifDate reads the date a photo was taken from the EXIF data of a JPEG or
+// TIFF based raw file.
+func exifDate(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	buf := make([]byte, 128*KB)
+	n, _ := io.ReadFull(f, buf)
+	buf = buf[:n]
+	tiff := buf
+	if len(buf) > 2 && buf[0] == 0xFF && buf[1] == 0xD8 {
+		tiff = nil
+		for i := 2; i+4 <= len(buf) && buf[i] == 0xFF; {
+			marker := buf[i+1]
+			if marker == 0xDA {
+				break
+			}
+			size := int(buf[i+2])<<8 | int(buf[i+3])
+			end := i + 2 + size
+			if size < 2 || end > len(buf) {
+				// a malformed or cut off segment ends the scan
+				break
+			}
+			if segment := buf[i+4 : end]; marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				tiff = segment[6:]
+				break
+			}
+			i = end
+		}
+	}
+	if len(tiff) < 8 {
+		return time.Time{}, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, false
+	}
+	ifd0 := order.Uint32(tiff[4:])
+	date := ifdString(tiff, order, ifd0, 0x0132) // DateTime
+	if exif, ok := ifdValue(tiff, order, ifd0, 0x8769); ok {
+		if original := ifdString(tiff, order, exif, 0x9003); original != "" { // DateTimeOriginal
+			date = original
+		}
+	}
+	taken, err := time.ParseInLocation("2006:01:02 15:04:05", date, time.Local)
+	return taken, err == nil
+}
+
+// ifdValue returns the value field of a tag in the TIFF directory at offset.
+func ifdValue(tiff []byte, order binary.ByteOrder, offset uint32, tag uint16) (uint32, bool) {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return 0, false
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := int(offset) + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == tag {
+			return order.Uint32(tiff[entry+8:]), true
+		}
+	}
+	return 0, false
+}
+
+// ifdString returns an ASCII tag, whose value field points at the text.
+func ifdString(tiff []byte, order binary.ByteOrder, offset uint32, tag uint16) string {
+	at, ok := ifdValue(tiff, order, offset, tag)
+	if !ok || uint64(at)+19 > uint64(len(tiff)) {
+		return ""
+	}
+	return string(tiff[at : at+19])
+}
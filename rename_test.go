@@ -0,0 +1,143 @@
+package dirk
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// exifTIFF builds little endian TIFF data whose EXIF IFD holds
+// DateTimeOriginal.
+func exifTIFF(date string) []byte {
+	le := binary.LittleEndian
+	tiff := make([]byte, 64)
+	copy(tiff, "II*\x00")
+	le.PutUint32(tiff[4:], 8)
+	le.PutUint16(tiff[8:], 1) // IFD0 with one entry pointing at the EXIF IFD
+	le.PutUint16(tiff[10:], 0x8769)
+	le.PutUint16(tiff[12:], 4)
+	le.PutUint32(tiff[14:], 1)
+	le.PutUint32(tiff[18:], 26)
+	le.PutUint16(tiff[26:], 1) // EXIF IFD with DateTimeOriginal
+	le.PutUint16(tiff[28:], 0x9003)
+	le.PutUint16(tiff[30:], 2)
+	le.PutUint32(tiff[32:], 20)
+	le.PutUint32(tiff[36:], 44)
+	copy(tiff[44:], date+"\x00")
+	return tiff
+}
+
+// jpegSegment returns a JPEG marker segment, its length field set to size.
+func jpegSegment(marker byte, size int, payload []byte) []byte {
+	return append([]byte{0xFF, marker, byte(size >> 8), byte(size)}, payload...)
+}
+
+func jpeg(segments ...[]byte) []byte {
+	data := []byte{0xFF, 0xD8}
+	for _, segment := range segments {
+		data = append(data, segment...)
+	}
+	return append(data, 0xFF, 0xDA)
+}
+
+func TestExifDate(t *testing.T) {
+	exif := append([]byte("Exif\x00\x00"), exifTIFF("2021:07:04 10:11:12")...)
+	taken := time.Date(2021, 7, 4, 10, 11, 12, 0, time.Local)
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"jpeg", jpeg(jpegSegment(0xE1, len(exif)+2, exif)), true},
+		{"after app0", jpeg(jpegSegment(0xE0, 6, []byte("JFIF")), jpegSegment(0xE1, len(exif)+2, exif)), true},
+		{"tiff", exifTIFF("2021:07:04 10:11:12"), true},
+		{"empty", nil, false},
+		{"soi only", []byte{0xFF, 0xD8}, false},
+		{"cut off marker", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00}, false},
+		{"zero length", jpeg(jpegSegment(0xE1, 0, exif)), false},
+		{"length one", jpeg(jpegSegment(0xE1, 1, exif)), false},
+		{"length past the end", jpeg(jpegSegment(0xE1, 0xFFFF, exif)), false},
+		{"cut off app1", jpeg(jpegSegment(0xE1, len(exif)+2, exif[:20]))[:26], false},
+		{"garbage app1", jpeg(jpegSegment(0xE1, 10, []byte("Exif\x00\x00\xFF\xFF"))), false},
+		{"bad byte order", jpeg(jpegSegment(0xE1, 16, []byte("Exif\x00\x00XX*\x00\x08\x00\x00\x00"))), false},
+		{"ifd past the end", jpeg(jpegSegment(0xE1, 16, []byte("Exif\x00\x00II*\x00\xFF\xFF\xFF\xFF"))), false},
+		{"bad date", exifTIFF("yesterday"), false},
+	}
+	dir := t.TempDir()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, "photo.jpg")
+			if err := os.WriteFile(path, test.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			date, ok := exifDate(path)
+			if ok != test.ok {
+				t.Fatalf("got ok %v, want %v", ok, test.ok)
+			}
+			if ok && !date.Equal(taken) {
+				t.Fatalf("got %v, want %v", date, taken)
+			}
+		})
+	}
+}
+
+func TestRenamerPlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		renamer Renamer
+		want    []string
+	}{
+		{"glob", []string{"IMG_1.JPG", "IMG_2.jpg", "notes.txt"}, Renamer{Match: "IMG_*.[jJ]*", Template: "trip-{n:03}-{1}.{ext|lower}"},
+			[]string{"trip-001-1.jpg", "trip-002-2.jpg", "notes.txt"}},
+		{"regex", []string{"a-1", "b-2"}, Renamer{Regex: regexp.MustCompile(`^(.)-(.)$`), Template: "{2}-{1}"},
+			[]string{"1-a", "2-b"}},
+		{"swap", []string{"a", "b"}, Renamer{Regex: regexp.MustCompile(`^[ab]$`), Template: "{n}"},
+			[]string{"1", "2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var files Files
+			for _, name := range test.files {
+				path := filepath.Join(dir, name)
+				os.WriteFile(path, []byte(name), 0644)
+				f, _ := MakeFile(path)
+				files = append(files, &f)
+			}
+			plan, err := test.renamer.Plan(files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := plan.Apply(); err != nil {
+				t.Fatal(err)
+			}
+			for i, name := range test.want {
+				content, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil || string(content) != test.files[i] {
+					t.Errorf("%s: got %q, %v, want the content of %s", name, content, err, test.files[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRenamesOrderSwaps(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	os.WriteFile(a, []byte("a"), 0644)
+	os.WriteFile(b, []byte("b"), 0644)
+	plan := Renames{{From: a, To: b}, {From: b, To: a}}.order()
+	if err := plan.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(a); string(content) != "b" {
+		t.Fatalf("a holds %q after the swap", content)
+	}
+	if content, _ := os.ReadFile(b); string(content) != "a" {
+		t.Fatalf("b holds %q after the swap", content)
+	}
+}
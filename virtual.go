@@ -0,0 +1,415 @@
+package dirk
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Files inside archives are addressed as the archive path, a double slash
+// and the path of the member, such as release.zip//docs/README. They can be
+// listed, previewed, searched and copied out like any other file, but not
+// modified. The root of an archive is release.zip//, and ListDir and
+// Childrens on the archive itself list that root.
+
+// archiveIndex is the tree of members of one archive.
+type archiveIndex struct {
+	mtime    int64
+	size     int64
+	entries  map[string]ArchiveEntry // by clean member path, "" is the root
+	children map[string][]string     // names in each folder, sorted
+	copies   map[string]int          // members of each name, the last one wins
+}
+
+var indexes = struct {
+	sync.Mutex
+	data map[string]*archiveIndex
+}{data: map[string]*archiveIndex{}}
+
+// splitVirtual splits a path inside an archive into the archive and the
+// member path.
+func splitVirtual(p string) (archive, name string, ok bool) {
+	for i := strings.Index(p, "//"); i >= 0; {
+		if info, err := os.Stat(p[:i]); err == nil && info.Mode().IsRegular() {
+			return p[:i], cleanEntry(p[i+2:]), true
+		}
+		next := strings.Index(p[i+2:], "//")
+		if next < 0 {
+			break
+		}
+		i += 2 + next
+	}
+	return "", "", false
+}
+
+// isVirtual reports whether p points inside an archive.
+func isVirtual(p string) bool {
+	_, _, ok := splitVirtual(p)
+	return ok
+}
+
+// cleanEntry normalises a member name, so no member can point outside of
+// its archive.
+func cleanEntry(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func virtualPath(archive, name string) string {
+	return archive + "//" + name
+}
+
+// loadIndex reads the members of an archive, reusing the last index while
+// the archive is unchanged.
+func loadIndex(archive string) (*archiveIndex, error) {
+	info, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+	indexes.Lock()
+	idx, ok := indexes.data[archive]
+	indexes.Unlock()
+	if ok && idx.mtime == info.ModTime().UnixNano() && idx.size == info.Size() {
+		return idx, nil
+	}
+
+	idx = &archiveIndex{
+		mtime:    info.ModTime().UnixNano(),
+		size:     info.Size(),
+		entries:  map[string]ArchiveEntry{},
+		children: map[string][]string{},
+		copies:   map[string]int{},
+	}
+	root := ArchiveEntry{Mode: os.ModeDir | info.Mode().Perm(), ModTime: info.ModTime(), CompressedSize: -1}
+	idx.entries[""] = root
+	err = eachEntry(archive, func(e ArchiveEntry) error {
+		name := cleanEntry(e.Name)
+		if name == "" {
+			return nil
+		}
+		e.Name = name
+		idx.add(e, root)
+		idx.copies[name]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for dir := range idx.children {
+		sort.Strings(idx.children[dir])
+	}
+	indexes.Lock()
+	indexes.data[archive] = idx
+	indexes.Unlock()
+	return idx, nil
+}
+
+// add records e and any folder above it the archive does not list.
+func (idx *archiveIndex) add(e ArchiveEntry, root ArchiveEntry) {
+	if _, ok := idx.entries[e.Name]; !ok {
+		dir := path.Dir(e.Name)
+		if dir == "." {
+			dir = ""
+		}
+		idx.children[dir] = append(idx.children[dir], path.Base(e.Name))
+		if _, ok := idx.entries[dir]; !ok {
+			idx.add(ArchiveEntry{Name: dir, Mode: root.Mode, ModTime: root.ModTime, CompressedSize: -1}, root)
+		}
+	}
+	idx.entries[e.Name] = e
+}
+
+// resolve follows the links of a member to the member holding its content.
+func (idx *archiveIndex) resolve(name string) (ArchiveEntry, bool) {
+	for hops := 0; hops < 16; hops++ {
+		e, ok := idx.entries[name]
+		if !ok || e.Link == "" {
+			return e, ok
+		}
+		if e.Mode&os.ModeSymlink != 0 && !path.IsAbs(e.Link) {
+			name = cleanEntry(path.Join(path.Dir(name), e.Link))
+		} else {
+			name = cleanEntry(e.Link)
+		}
+	}
+	return ArchiveEntry{}, false
+}
+
+// virtualInfo describes a member as an os.FileInfo.
+type virtualInfo struct {
+	entry ArchiveEntry
+	stat  *syscall.Stat_t
+}
+
+func newVirtualInfo(e ArchiveEntry) *virtualInfo {
+	ts := syscall.NsecToTimespec(e.ModTime.UnixNano())
+	return &virtualInfo{entry: e, stat: &syscall.Stat_t{Size: e.Size, Mtim: ts, Atim: ts, Ctim: ts}}
+}
+
+func (v *virtualInfo) Name() string       { return path.Base("/" + v.entry.Name) }
+func (v *virtualInfo) Size() int64        { return v.entry.Size }
+func (v *virtualInfo) Mode() os.FileMode  { return v.entry.Mode }
+func (v *virtualInfo) ModTime() time.Time { return v.entry.ModTime }
+func (v *virtualInfo) IsDir() bool        { return v.entry.IsDir() }
+func (v *virtualInfo) Sys() interface{}   { return v.stat }
+
+// statAny stats a path on disk or inside an archive, following links.
+func statAny(p string) (os.FileInfo, error) {
+	info, err := os.Stat(p)
+	if err == nil {
+		return info, nil
+	}
+	archive, name, ok := splitVirtual(p)
+	if !ok {
+		return nil, err
+	}
+	idx, err := loadIndex(archive)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := idx.resolve(name)
+	if !ok {
+		// a dangling link is shown as the link itself
+		if e, ok = idx.entries[name]; !ok {
+			return nil, &os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist}
+		}
+	}
+	e.Name = name
+	return newVirtualInfo(e), nil
+}
+
+// openAny opens a file on disk or inside an archive for reading.
+func openAny(p string) (io.ReadCloser, error) {
+	file, err := os.Open(p)
+	if err == nil {
+		return file, nil
+	}
+	if _, _, ok := splitVirtual(p); !ok {
+		return nil, err
+	}
+	return openVirtual(p)
+}
+
+// openVirtual streams the content of a member. For tar based archives the
+// archive is read up to the member.
+func openVirtual(p string) (io.ReadCloser, error) {
+	archive, name, _ := splitVirtual(p)
+	idx, err := loadIndex(archive)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := idx.resolve(name)
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+	if e.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: p, Err: syscall.EISDIR}
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		found, earlier := false, idx.copies[e.Name]-1
+		err := walkArchive(archive, func(member ArchiveEntry, open func() (io.ReadCloser, error)) error {
+			if found || cleanEntry(member.Name) != e.Name {
+				return nil
+			}
+			if earlier > 0 {
+				earlier--
+				return nil
+			}
+			found = true
+			content, err := open()
+			if err != nil {
+				return err
+			}
+			defer content.Close()
+			if _, err := io.Copy(writer, content); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		})
+		if err == filepath.SkipDir {
+			err = nil
+		} else if err == nil && !found {
+			err = &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader, nil
+}
+
+// virtualRoot returns the path browsing dir lists when dir is an archive
+// or a folder inside one.
+func virtualRoot(dir string) (string, bool) {
+	if _, _, ok := splitVirtual(dir); ok {
+		return dir, true
+	}
+	if info, err := os.Stat(dir); err == nil && info.Mode().IsRegular() && detectArchive(dir) != "" {
+		return virtualPath(dir, ""), true
+	}
+	return "", false
+}
+
+// readDirAny reads a folder on disk or inside an archive.
+func readDirAny(dir string) ([]os.FileInfo, error) {
+	root, ok := virtualRoot(dir)
+	if !ok {
+		return ioutil.ReadDir(dir)
+	}
+	children, err := virtualChildren(root, false)
+	if err != nil {
+		return nil, err
+	}
+	infos := []os.FileInfo{}
+	for _, child := range children {
+		if info, err := statAny(child); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// virtualChildren lists the members in a folder of an archive, and those
+// in all folders below it when recurrent is set.
+func virtualChildren(dir string, recurrent bool) ([]string, error) {
+	archive, name, _ := splitVirtual(dir)
+	idx, err := loadIndex(archive)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := idx.resolve(name); !ok || !e.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: dir, Err: syscall.ENOTDIR}
+	}
+	paths := []string{}
+	var list func(dir string)
+	list = func(dir string) {
+		for _, child := range idx.children[dir] {
+			member := path.Join(dir, child)
+			paths = append(paths, virtualPath(archive, member))
+			if recurrent && idx.entries[member].IsDir() {
+				list(member)
+			}
+		}
+	}
+	list(name)
+	return paths, nil
+}
+
+// virtualParent returns the folder holding a member, the archive's own
+// folder for its root.
+func virtualParent(p string) (string, bool) {
+	archive, name, ok := splitVirtual(p)
+	if !ok {
+		return "", false
+	}
+	if name == "" {
+		return filepath.Dir(archive) + "/", true
+	}
+	if dir := path.Dir(name); dir != "." {
+		return virtualPath(archive, dir) + "/", true
+	}
+	return virtualPath(archive, ""), true
+}
+
+// measureVirtual sums the size and number of the regular members under p.
+func measureVirtual(p string) (bytes int64, files int, ok bool) {
+	archive, name, ok := splitVirtual(p)
+	if !ok {
+		return 0, 0, false
+	}
+	idx, err := loadIndex(archive)
+	if err != nil {
+		return 0, 0, true
+	}
+	for member, e := range idx.entries {
+		if e.Mode.IsRegular() && e.Link == "" && (name == "" || member == name || strings.HasPrefix(member, name+"/")) {
+			if e.Size > 0 {
+				bytes += e.Size
+			}
+			files++
+		}
+	}
+	return bytes, files, true
+}
+
+// cpVirtual copies a member, and everything below it when it is a folder,
+// out of its archive to dst, reading the archive once. A folder is copied
+// like an archive is extracted, so its links cannot lead out of dst and the
+// MaxExtractSize and MaxExtractEntries limits apply.
+func cpVirtual(src, dst string, tr *tracker) error {
+	archive, name, _ := splitVirtual(src)
+	idx, err := loadIndex(archive)
+	if err != nil {
+		return err
+	}
+	e, ok := idx.resolve(name)
+	if !ok {
+		return &os.PathError{Op: "copy", Path: src, Err: os.ErrNotExist}
+	}
+	if !e.IsDir() {
+		// a link is copied as the member it points to
+		in, err := openVirtual(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		left := MaxExtractSize
+		e.Xattrs = restorable(e.Xattrs, nil)
+		return writeMember(dst, e, capped{in, &left}, tr)
+	}
+
+	prefix := e.Name
+	if prefix != "" {
+		prefix += "/"
+	}
+	return unpack(dst, ArchiveOptions{}, tr, func(fn func(ArchiveEntry, func() (io.ReadCloser, error)) error) error {
+		return walkArchive(archive, func(member ArchiveEntry, open func() (io.ReadCloser, error)) error {
+			member.Name = cleanEntry(member.Name)
+			switch {
+			case member.Name == e.Name:
+				member.Name = "."
+			case strings.HasPrefix(member.Name, prefix):
+				member.Name = strings.TrimPrefix(member.Name, prefix)
+			default:
+				return nil
+			}
+			if member.Link != "" && member.Mode&os.ModeSymlink == 0 {
+				// hard links name a member from the root of the archive
+				link := cleanEntry(member.Link)
+				if !strings.HasPrefix(link, prefix) {
+					// the member linked is not copied, copy its content
+					member.Link = ""
+					return fn(member, func() (io.ReadCloser, error) {
+						return openVirtual(virtualPath(archive, link))
+					})
+				}
+				member.Link = strings.TrimPrefix(link, prefix)
+			}
+			return fn(member, open)
+		})
+	})
+}
+
+func writeMember(dst string, e ArchiveEntry, content io.Reader, tr *tracker) error {
+	tr.file(dst)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, e.Mode.Perm()|0200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, tr.reader(content)); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	tr.fileDone()
+	setXattrs(dst, e.Xattrs)
+	os.Chmod(dst, e.Mode.Perm())
+	return os.Chtimes(dst, e.ModTime, e.ModTime)
+}
@@ -0,0 +1,245 @@
+package dirk
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testVirtualArchives packs a tree with a nested folder and a link into a
+// zip and a tar.gz below dir.
+func testVirtualArchives(t *testing.T, dir string) []string {
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(src, "in", "deep"), 0755)
+	os.WriteFile(filepath.Join(src, "in", "a.txt"), []byte("hello world\nsecond line\n"), 0644)
+	os.WriteFile(filepath.Join(src, "in", "deep", "b.txt"), []byte("bee"), 0600)
+	os.Symlink("in/a.txt", filepath.Join(src, "link"))
+	var archives []string
+	for _, name := range []string{"a.zip", "a.tar.gz"} {
+		path := filepath.Join(dir, name)
+		if err := archive([]string{src}, path, nil); err != nil {
+			t.Fatal(err)
+		}
+		archives = append(archives, path)
+	}
+	return archives
+}
+
+func TestVirtualBrowse(t *testing.T) {
+	for _, path := range testVirtualArchives(t, t.TempDir()) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			archive, _ := MakeFile(path)
+			if got := archive.Childrens().paths(); !reflect.DeepEqual(got, []string{path + "//src"}) {
+				t.Fatalf("archive holds %v", got)
+			}
+			src, err := MakeFile(path + "//src")
+			if err != nil || !src.IsDir() {
+				t.Fatalf("src: %v", err)
+			}
+			names := []string{}
+			for _, f := range src.Childrens() {
+				names = append(names, f.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, []string{"in", "link"}) {
+				t.Fatalf("src holds %v", names)
+			}
+			tests := []struct {
+				name, content string
+			}{
+				{"src/in/a.txt", "hello world\nsecond line\n"},
+				{"src/in/deep/b.txt", "bee"},
+				{"src/link", "hello world\nsecond line\n"},
+				{"src/in/../../src/in/deep/b.txt", "bee"},
+			}
+			for _, test := range tests {
+				content, err := readAll(path + "//" + test.name)
+				if err != nil || string(content) != test.content {
+					t.Errorf("%s: got %q, %v", test.name, content, err)
+				}
+			}
+			if _, err := readAll(path + "//src/missing"); !os.IsNotExist(err) {
+				t.Errorf("missing member gives %v", err)
+			}
+			if _, err := readAll(path + "//src/in"); err == nil {
+				t.Error("read a folder")
+			}
+
+			file, err := MakeFile(path + "//src/in/a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file.File.Size() != 24 || file.IsDir() || file.Parent()[0].Path != path+"//src/in/" {
+				t.Errorf("a.txt: %d bytes, parent %s", file.File.Size(), file.Parent()[0].Path)
+			}
+			if preview, err := file.Preview(0, 1); err != nil || preview != "hello world" {
+				t.Errorf("preview %q, %v", preview, err)
+			}
+			if offsets, err := file.SearchBytes([]byte("second"), 0); err != nil || !reflect.DeepEqual(offsets, []int64{12}) {
+				t.Errorf("search gives %v, %v", offsets, err)
+			}
+			if found := (Files{&file}).Find(Finder{Bytes: []byte("second")}); len(found) != 1 {
+				t.Errorf("find gives %d files", len(found))
+			}
+		})
+	}
+}
+
+func TestVirtualPaste(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range testVirtualArchives(t, dir) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			out := t.TempDir()
+			destin, _ := MakeFile(out)
+			files, err := MakeFiles([]string{path + "//src/in", path + "//src/link"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := files.Paste(destin); err != nil {
+				t.Fatal(err)
+			}
+			tests := []struct {
+				name, content string
+				mode          os.FileMode
+			}{
+				{"in/a.txt", "hello world\nsecond line\n", 0644},
+				{"in/deep/b.txt", "bee", 0600},
+				{"link", "hello world\nsecond line\n", 0644}, // a link is copied as its target
+			}
+			for _, test := range tests {
+				target := filepath.Join(out, filepath.FromSlash(test.name))
+				content, err := os.ReadFile(target)
+				if err != nil || string(content) != test.content {
+					t.Errorf("%s: got %q, %v", test.name, content, err)
+					continue
+				}
+				if info, _ := os.Lstat(target); info.Mode() != test.mode {
+					t.Errorf("%s: mode %v, want %v", test.name, info.Mode(), test.mode)
+				}
+			}
+			// archives are read-only
+			for _, plan := range []func() (*Plan, error){files.DeletePlan, func() (*Plan, error) { return files.MovePlan(destin) }} {
+				p, _ := plan()
+				if len(p.Actions) != 2 || p.Actions[0].Kind != "skip" {
+					t.Errorf("%s plans %+v", p.Op, p.Actions)
+				}
+			}
+		})
+	}
+}
+
+func TestVirtualPasteLinks(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	os.Mkdir(outside, 0755)
+	os.WriteFile(filepath.Join(outside, "victim"), []byte("safe"), 0644)
+	tests := []struct {
+		name    string
+		members []testMember
+		ok      bool
+		content map[string]string
+	}{
+		{"write through link", []testMember{
+			{name: "d/", typ: tar.TypeDir},
+			{name: "d/a", link: outside, typ: tar.TypeSymlink},
+			{name: "d/a/victim", typ: tar.TypeReg, body: "owned"},
+			{name: "d/a/new", typ: tar.TypeReg, body: "owned"},
+		}, false, nil},
+		{"link up", []testMember{
+			{name: "d/s", link: "../..", typ: tar.TypeSymlink},
+		}, false, nil},
+		{"link chain", []testMember{
+			{name: "d/x/", typ: tar.TypeDir},
+			{name: "d/x/s", link: "..", typ: tar.TypeSymlink},
+			{name: "d/b", link: "x/s/..", typ: tar.TypeSymlink},
+		}, false, nil},
+		{"links inside", []testMember{
+			{name: "other", typ: tar.TypeReg, body: "other"},
+			{name: "d/f", typ: tar.TypeReg, body: "f"},
+			{name: "d/s", link: "f", typ: tar.TypeSymlink},
+			{name: "d/h", link: "d/f", typ: tar.TypeLink},
+			{name: "d/o", link: "other", typ: tar.TypeLink},
+		}, true, map[string]string{"f": "f", "s": "f", "h": "f", "o": "other"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "evil.tar")
+			writeTestTar(t, path, test.members)
+			out := t.TempDir()
+			destin, _ := MakeFile(out)
+			files := Files{&File{Path: path + "//d", Name: "d"}}
+			err := files.Paste(destin)
+			if (err == nil) != test.ok {
+				t.Fatalf("got %v", err)
+			}
+			if !test.ok {
+				if _, err := os.Lstat(filepath.Join(out, "d")); !os.IsNotExist(err) {
+					t.Error("partial copy left behind")
+				}
+			}
+			for name, want := range test.content {
+				if content, err := os.ReadFile(filepath.Join(out, "d", name)); string(content) != want {
+					t.Errorf("%s: got %q, %v", name, content, err)
+				}
+			}
+			entries, _ := os.ReadDir(outside)
+			if content, _ := os.ReadFile(filepath.Join(outside, "victim")); string(content) != "safe" || len(entries) != 1 {
+				t.Fatal("wrote outside the destination")
+			}
+		})
+	}
+}
+
+func TestVirtualPasteLimits(t *testing.T) {
+	defer func(size int64, entries int) { MaxExtractSize, MaxExtractEntries = size, entries }(MaxExtractSize, MaxExtractEntries)
+	path := filepath.Join(t.TempDir(), "big.tar")
+	writeTestTar(t, path, []testMember{
+		{name: "d/a", typ: tar.TypeReg, body: string(make([]byte, 1000))},
+		{name: "d/b", typ: tar.TypeReg, body: string(make([]byte, 1000))},
+	})
+	tests := []struct {
+		name    string
+		source  string
+		size    int64
+		entries int
+		err     error
+	}{
+		{"folder size", "d", 1500, 10, errExtractSize},
+		{"folder entries", "d", 1 << 20, 1, errExtractEntries},
+		{"file size", "d/a", 500, 10, errExtractSize},
+	}
+	for _, test := range tests {
+		MaxExtractSize, MaxExtractEntries = test.size, test.entries
+		err := cpVirtual(path+"//"+test.source, filepath.Join(t.TempDir(), "out"), nil)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestVirtualIndexReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.tar")
+	writeTestTar(t, path, []testMember{{name: "old", typ: tar.TypeReg, body: "old"}})
+	if _, err := statAny(path + "//old"); err != nil {
+		t.Fatal(err)
+	}
+	// rewriting the archive drops the cached index
+	writeTestTar(t, path, []testMember{{name: "new", typ: tar.TypeReg, body: "newer"}})
+	if _, err := statAny(path + "//old"); !os.IsNotExist(err) {
+		t.Fatalf("old member gives %v", err)
+	}
+	r, err := openAny(path + "//new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if content, _ := io.ReadAll(r); string(content) != "newer" {
+		t.Fatalf("got %q", content)
+	}
+}
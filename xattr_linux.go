@@ -0,0 +1,46 @@
+//go:build linux
+// +build linux
+
+package dirk
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// xattrs reads the extended attributes of path, without following a link.
+// Attributes the user may not read are left out.
+func xattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err == unix.ENOTSUP || err == unix.EOPNOTSUPP || size == 0 {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	names := make([]byte, size)
+	if size, err = unix.Llistxattr(path, names); err != nil {
+		return nil, err
+	}
+	attrs := map[string]string{}
+	for _, name := range strings.Split(strings.TrimRight(string(names[:size]), "\x00"), "\x00") {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, size)
+		if size, err = unix.Lgetxattr(path, name, value); err != nil {
+			continue
+		}
+		attrs[name] = string(value[:size])
+	}
+	return attrs, nil
+}
+
+// setXattrs sets the extended attributes of path, without following a link,
+// as far as the file system and the rights of the user allow.
+func setXattrs(path string, attrs map[string]string) {
+	for name, value := range attrs {
+		unix.Lsetxattr(path, name, []byte(value), 0)
+	}
+}
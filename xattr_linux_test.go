@@ -0,0 +1,84 @@
+package dirk
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestTarXattrs(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(src, "d"), 0755)
+	os.WriteFile(filepath.Join(src, "f"), []byte("content"), 0444)
+	attrs := map[string]string{"f": "blue", "d": "green"}
+	for name, value := range attrs {
+		if err := unix.Lsetxattr(filepath.Join(src, name), "user.tag", []byte(value), 0); err != nil {
+			t.Skip("no user extended attributes here:", err)
+		}
+	}
+	os.Chmod(filepath.Join(src, "d"), 0555)
+	defer os.Chmod(filepath.Join(src, "d"), 0755)
+	tarball := filepath.Join(dir, "archive.tar")
+	if err := archive([]string{src}, tarball, nil); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := extract(tarball, out); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(out, "src", "d"), 0755)
+	for name, value := range attrs {
+		got, err := xattrs(filepath.Join(out, "src", name))
+		if err != nil || got["user.tag"] != value {
+			t.Errorf("%s: got %v, %v, want user.tag %q", name, got, err, value)
+		}
+	}
+}
+
+func TestExtractXattrNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	probe := filepath.Join(dir, "probe")
+	os.WriteFile(probe, nil, 0644)
+	for _, name := range []string{"user.tag", "trusted.tag"} {
+		if err := unix.Lsetxattr(probe, name, []byte("x"), 0); err != nil {
+			t.Skip("cannot set", name, "here:", err)
+		}
+	}
+	tarball := filepath.Join(dir, "archive.tar")
+	f, _ := os.Create(tarball)
+	w := tar.NewWriter(f)
+	w.WriteHeader(&tar.Header{Name: "f", Mode: 0644, Typeflag: tar.TypeReg, Format: tar.FormatPAX, PAXRecords: map[string]string{
+		"SCHILY.xattr.user.tag":     "user",
+		"SCHILY.xattr.trusted.tag":  "trusted",
+		"SCHILY.xattr.security.tag": "security",
+	}})
+	w.Close()
+	f.Close()
+	tests := []struct {
+		name       string
+		namespaces []string
+		want       map[string]string
+	}{
+		{"user only", nil, map[string]string{"user.tag": "user"}},
+		{"trusted too", []string{"trusted"}, map[string]string{"user.tag": "user", "trusted.tag": "trusted"}},
+	}
+	for _, test := range tests {
+		in, _ := os.Open(tarball)
+		out := filepath.Join(dir, test.name)
+		err := ReadArchive(in, "tar", out, ArchiveOptions{XattrNamespaces: test.namespaces})
+		in.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := xattrs(filepath.Join(out, "f"))
+		delete(got, "security.selinux")
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package dirk
+
+func xattrs(path string) (map[string]string, error) { return nil, nil }
+
+func setXattrs(path string, attrs map[string]string) {}
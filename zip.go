@@ -0,0 +1,261 @@
+package dirk
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// spoolMemory is how much compressed data a member keeps in memory before
+// moving it to a temporary file.
+const spoolMemory = 1 << 20
+
+func zipit(sources []string, target io.Writer, opt ArchiveOptions, tr *tracker) error {
+	archive := zip.NewWriter(target)
+	if err := zipSources(archive, sources, opt, tr); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// zipMember is a member waiting to be added to a zip. Members compressed
+// ahead report on done and hold a zip of their own in data.
+type zipMember struct {
+	path   string
+	info   os.FileInfo
+	header *zip.FileHeader
+	done   chan error
+	data   *spool
+}
+
+// zipSources adds sources and everything below them to archive. Content
+// that is compressed already, such as images, videos and archives, is
+// stored as it is. Other files are deflated by up to opt.Parallel workers
+// at once and added in order.
+func zipSources(archive *zip.Writer, sources []string, opt ArchiveOptions, tr *tracker) (err error) {
+	registerLevel(archive, opt.Level)
+	parallel := opt.Parallel
+	if parallel < 1 {
+		parallel = runtime.NumCPU()
+	}
+	// members are only reported once they are added, the walk runs ahead
+	progress := opt.Progress
+	opt.Progress = nil
+	members := []*zipMember{}
+	err = walkSources(sources, opt, func(path, name string, info os.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		m := &zipMember{path: path, info: info, header: header}
+		if info.Mode().IsRegular() {
+			header.Method = zip.Deflate
+			if info.Size() == 0 || compressedAlready(path) {
+				header.Method = zip.Store
+			} else if parallel > 1 {
+				m.done = make(chan error, 1)
+			}
+		}
+		members = append(members, m)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slots := make(chan struct{}, parallel)
+	stop := make(chan struct{})
+	produced := make(chan struct{}) // closed once no more workers start
+	var workers sync.WaitGroup
+	defer func() {
+		close(stop)
+		if err != nil {
+			// drop what the workers still compress
+			go func() {
+				<-produced
+				workers.Wait()
+				for _, m := range members {
+					if m.data != nil {
+						m.data.Close()
+					}
+				}
+			}()
+		}
+	}()
+	go func() {
+		defer close(produced)
+		for _, m := range members {
+			if m.done == nil {
+				continue
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-stop:
+				return
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			workers.Add(1)
+			go func(m *zipMember) {
+				defer workers.Done()
+				data, err := compressAhead(m.path, m.header, opt.Level, tr)
+				m.data = data
+				m.done <- err
+			}(m)
+		}
+	}()
+
+	for _, m := range members {
+		if progress != nil {
+			progress(sourceEntry(m.path, m.header.Name, m.info))
+		}
+		if m.done != nil {
+			if err := <-m.done; err != nil {
+				return err
+			}
+			err := m.data.copyTo(archive)
+			m.data.Close()
+			m.data = nil
+			<-slots
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		writer, err := archive.CreateHeader(m.header)
+		if err != nil {
+			return err
+		}
+		switch {
+		case m.info.Mode()&os.ModeSymlink != 0:
+			// zip keeps the target of a link as its content
+			link, err := os.Readlink(m.path)
+			if err != nil {
+				return err
+			}
+			if _, err = io.WriteString(writer, link); err != nil {
+				return err
+			}
+		case m.info.Mode().IsRegular():
+			if err := copyMember(writer, m.path, tr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func registerLevel(archive *zip.Writer, level int) {
+	if level == 0 {
+		return
+	}
+	archive.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+}
+
+// compressAhead deflates a file into a zip of its own, which copyTo then
+// adds to the real archive without compressing it again.
+func compressAhead(path string, header *zip.FileHeader, level int, tr *tracker) (*spool, error) {
+	data := &spool{}
+	single := zip.NewWriter(data)
+	registerLevel(single, level)
+	writer, err := single.CreateHeader(header)
+	if err == nil {
+		err = copyMember(writer, path, tr)
+	}
+	if err == nil {
+		err = single.Close()
+	}
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	return data, nil
+}
+
+// compressedAlready reports whether the file holds content compressed by
+// its format, which deflate would only waste time on.
+func compressedAlready(path string) bool {
+	mime, _, err := DetectFile(path)
+	if err != nil {
+		return false
+	}
+	for _, node := range []*Node{
+		Png, Jpg, Gif, Webp,
+		Mp3, Flac, Ape, MusePack, Amr, Ogg,
+		Mp4, WebM, Mpeg, QuickTime, ThreeGP, Avi, Flv, Mkv,
+		SevenZ, Zip, Xlsx, Docx, Pptx, Epub, Jar, Apk, Gzip, Bzip2, Xz, Zstd,
+	} {
+		if node.Mime() == mime {
+			return true
+		}
+	}
+	return false
+}
+
+// spool holds data in memory, or in a temporary file once it outgrows
+// spoolMemory.
+type spool struct {
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil && s.buf.Len()+len(p) > spoolMemory {
+		file, err := ioutil.TempFile("", "dirk-spool-")
+		if err != nil {
+			return 0, err
+		}
+		// the file is gone once closed
+		os.Remove(file.Name())
+		s.file = file
+		if _, err := s.buf.WriteTo(file); err != nil {
+			return 0, err
+		}
+	}
+	var n int
+	var err error
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// copyTo adds the member of the zip held by the spool to archive.
+func (s *spool) copyTo(archive *zip.Writer) error {
+	var data io.ReaderAt = bytes.NewReader(s.buf.Bytes())
+	if s.file != nil {
+		data = s.file
+	}
+	reader, err := zip.NewReader(data, s.size)
+	if err != nil {
+		return err
+	}
+	for _, file := range reader.File {
+		if err := archive.Copy(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *spool) Close() error {
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
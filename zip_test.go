@@ -0,0 +1,156 @@
+package dirk
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testZipTree makes src with text files, a PNG, a partly random file, a
+// link and an empty file below dir.
+func testZipTree(t *testing.T, dir string) string {
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(src, 0755)
+	random := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	random.Read(img.Pix)
+	f, _ := os.Create(filepath.Join(src, "picture.png"))
+	png.Encode(f, img)
+	f.Close()
+	for i := 0; i < 20; i++ {
+		os.WriteFile(filepath.Join(src, string(rune('a'+i))+".txt"), bytes.Repeat([]byte("hello world "), 1000*(i+1)), 0644)
+	}
+	big := make([]byte, 3<<20)
+	random.Read(big[:1<<20])
+	os.WriteFile(filepath.Join(src, "big.bin"), big, 0644)
+	os.Symlink("a.txt", filepath.Join(src, "link"))
+	os.WriteFile(filepath.Join(src, "empty"), nil, 0644)
+	return src
+}
+
+func TestZipParallel(t *testing.T) {
+	dir := t.TempDir()
+	src := testZipTree(t, dir)
+	files, _ := MakeFiles([]string{src})
+	methods := map[string]uint16{
+		"src/picture.png": zip.Store,
+		"src/empty":       zip.Store,
+		"src/a.txt":       zip.Deflate,
+		"src/big.bin":     zip.Deflate,
+	}
+	tests := []struct {
+		parallel, level int
+	}{
+		{1, 1}, {1, 9}, {4, 1}, {4, 9}, {0, 0},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := files.WriteArchive(&buf, "zip", ArchiveOptions{Parallel: test.parallel, Level: test.level}); err != nil {
+			t.Fatalf("%+v: %v", test, err)
+		}
+		r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("%+v: %v", test, err)
+		}
+		if len(r.File) != 25 {
+			t.Fatalf("%+v: got %d members, want 25", test, len(r.File))
+		}
+		for _, member := range r.File {
+			if want, ok := methods[member.Name]; ok && member.Method != want {
+				t.Errorf("%+v: %s stored with method %d, want %d", test, member.Name, member.Method, want)
+			}
+			if member.Name == "src/link" && member.Mode()&os.ModeSymlink == 0 {
+				t.Errorf("%+v: link stored as %v", test, member.Mode())
+			}
+		}
+		path := filepath.Join(t.TempDir(), "archive.zip")
+		os.WriteFile(path, buf.Bytes(), 0644)
+		if err := (File{Path: path}).TestArchive(); err != nil {
+			t.Fatalf("%+v: %v", test, err)
+		}
+	}
+}
+
+func TestZipProgress(t *testing.T) {
+	dir := t.TempDir()
+	src := testZipTree(t, dir)
+	files, _ := MakeFiles([]string{src})
+	for _, parallel := range []int{1, 4} {
+		var buf bytes.Buffer
+		var names []string
+		var written []int
+		progress := func(e ArchiveEntry) {
+			names = append(names, e.Name)
+			written = append(written, buf.Len())
+		}
+		if err := files.WriteArchive(&buf, "zip", ArchiveOptions{Parallel: parallel, Progress: progress}); err != nil {
+			t.Fatal(err)
+		}
+		r, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if len(names) != len(r.File) {
+			t.Fatalf("parallel %d: %d calls for %d members", parallel, len(names), len(r.File))
+		}
+		// each member is reported after the one before it is added, less
+		// what the zip writer still buffers, and before its own data
+		previous := int64(0)
+		for i, member := range r.File {
+			offset, _ := member.DataOffset()
+			if names[i] != member.Name || int64(written[i]) < previous-4096 || int64(written[i]) > offset {
+				t.Errorf("parallel %d: %s reported at %d bytes, data from %d", parallel, names[i], written[i], offset)
+			}
+			previous = offset
+		}
+	}
+}
+
+// failingWriter fails once more than left bytes are written to it.
+type failingWriter struct{ left int }
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.left -= len(p); w.left < 0 {
+		return 0, errWriteFailed
+	}
+	return len(p), nil
+}
+
+func TestZipParallelWriteError(t *testing.T) {
+	dir := t.TempDir()
+	src := testZipTree(t, dir)
+	files, _ := MakeFiles([]string{src})
+	for _, left := range []int{0, 1000, 20000, 200000} {
+		err := files.WriteArchive(&failingWriter{left: left}, "zip", ArchiveOptions{Parallel: 4})
+		if !errors.Is(err, errWriteFailed) {
+			t.Errorf("failing after %d bytes: got %v", left, err)
+		}
+	}
+}
+
+func TestTestArchiveZipCRC(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"one", "two", "three"} {
+		member, _ := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		member.Write([]byte("content of " + name))
+	}
+	w.Close()
+	data := buf.Bytes()
+	data[bytes.Index(data, []byte("content of two"))] = 'C'
+	path := filepath.Join(t.TempDir(), "archive.zip")
+	os.WriteFile(path, data, 0644)
+	err := File{Path: path}.TestArchive()
+	var res *Result
+	if !errors.As(err, &res) || len(res.Failed) != 1 || len(res.Succeeded) != 2 {
+		t.Fatalf("got %v", err)
+	}
+	if res.Failed[0].Path != virtualPath(path, "two") {
+		t.Fatalf("failed member %s", res.Failed[0].Path)
+	}
+}